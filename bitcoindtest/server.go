@@ -0,0 +1,268 @@
+// Package bitcoindtest provides an in-process bitcoind REST server with a
+// scripted chain and mempool, so Node can be run end to end without a
+// bitcoind. Only the json endpoints are served.
+package bitcoindtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/SwingbyProtocol/tx-indexer/btc"
+)
+
+const startTime = 1600000000
+
+type Server struct {
+	*httptest.Server
+	chain   string
+	blocks  []*btc.Block
+	orphans map[string]*btc.Block
+	mempool map[string]*btc.Tx
+	pool    map[string]btc.PoolTx
+	mu      sync.Mutex
+}
+
+// NewServer starts a server for chain ("main", "test", "regtest", ...) with
+// a genesis block as the tip
+func NewServer(chain string) *Server {
+	s := &Server{
+		chain:   chain,
+		orphans: make(map[string]*btc.Block),
+		mempool: make(map[string]*btc.Tx),
+		pool:    make(map[string]btc.PoolTx),
+	}
+	s.Mine()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/chaininfo.json", s.handleChainInfo)
+	mux.HandleFunc("/rest/blockhashbyheight/", s.handleBlockHash)
+	mux.HandleFunc("/rest/block/notxdetails/", s.handleBlockInfo)
+	mux.HandleFunc("/rest/block/", s.handleBlock)
+	mux.HandleFunc("/rest/tx/", s.handleTx)
+	mux.HandleFunc("/rest/mempool/contents.json", s.handleMempool)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Tip returns the hash and height of the best block
+func (s *Server) Tip() (string, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tip := s.blocks[len(s.blocks)-1]
+	return tip.Hash, tip.Height
+}
+
+func (s *Server) AddMempoolTx(tx *btc.Tx) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mempool[tx.Txid] = tx
+	s.pool[tx.Txid] = btc.PoolTx{
+		Height: int64(len(s.blocks) - 1),
+		Time:   s.blocks[len(s.blocks)-1].Time,
+	}
+}
+
+// RemoveMempoolTx drops a tx from the mempool as an eviction would
+func (s *Server) RemoveMempoolTx(txid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mempool, txid)
+	delete(s.pool, txid)
+}
+
+// Mine appends a block with a coinbase and txs to the tip. The txs are
+// removed from the mempool.
+func (s *Server) Mine(txs ...*btc.Tx) *btc.Block {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	height := int64(len(s.blocks))
+	prev := ""
+	if height > 0 {
+		prev = s.blocks[height-1].Hash
+	}
+	coinbase := NewTx([]*btc.Vin{Coinbase(height)}, Output(s.coinbaseAddress(), 50*1e8))
+	block := &btc.Block{
+		Hash:              hash(prev, strconv.FormatInt(height, 10), strconv.Itoa(len(s.orphans))),
+		Height:            height,
+		Time:              startTime + height*600,
+		Previousblockhash: prev,
+		Txs:               append([]*btc.Tx{coinbase}, txs...),
+	}
+	block.Ntx = int64(len(block.Txs))
+	s.blocks = append(s.blocks, block)
+	block.Mediantime = s.medianTime(height)
+	for _, tx := range txs {
+		delete(s.mempool, tx.Txid)
+		delete(s.pool, tx.Txid)
+	}
+	return block
+}
+
+// MineMempool mines a block with every tx in the mempool
+func (s *Server) MineMempool() *btc.Block {
+	s.mu.Lock()
+	txs := []*btc.Tx{}
+	for _, tx := range s.mempool {
+		txs = append(txs, tx)
+	}
+	s.mu.Unlock()
+	return s.Mine(txs...)
+}
+
+// Reorg disconnects the top depth blocks. Their txs return to the mempool
+// like in bitcoind, and the next Mine builds the competing chain.
+func (s *Server) Reorg(depth int) []*btc.Block {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if depth >= len(s.blocks) {
+		depth = len(s.blocks) - 1
+	}
+	orphaned := s.blocks[len(s.blocks)-depth:]
+	s.blocks = s.blocks[:len(s.blocks)-depth]
+	tip := s.blocks[len(s.blocks)-1]
+	for _, block := range orphaned {
+		s.orphans[block.Hash] = block
+		for _, tx := range block.Txs[1:] {
+			s.mempool[tx.Txid] = tx
+			s.pool[tx.Txid] = btc.PoolTx{Height: tip.Height, Time: tip.Time}
+		}
+	}
+	return orphaned
+}
+
+func (s *Server) coinbaseAddress() string {
+	if s.chain == "main" {
+		return "1BitcoinEaterAddressDontSendf59kuE"
+	}
+	return "mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn"
+}
+
+func (s *Server) medianTime(height int64) int64 {
+	start := height - 10
+	if start < 0 {
+		start = 0
+	}
+	// block times are increasing, so the median is in the middle
+	return s.blocks[start+(height-start+1)/2].Time
+}
+
+func (s *Server) getBlock(hash string) (*btc.Block, bool) {
+	for _, block := range s.blocks {
+		if block.Hash == hash {
+			copied := *block
+			copied.Confirmations = int64(len(s.blocks)) - block.Height
+			return &copied, true
+		}
+	}
+	block, ok := s.orphans[hash]
+	if ok == false {
+		return nil, false
+	}
+	copied := *block
+	copied.Confirmations = -1
+	return &copied, true
+}
+
+func (s *Server) handleChainInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tip := s.blocks[len(s.blocks)-1]
+	writeJSON(w, btc.ChainInfo{
+		Chain:         s.chain,
+		Blocks:        tip.Height,
+		Headers:       tip.Height,
+		Bestblockhash: tip.Hash,
+	})
+}
+
+func (s *Server) handleBlockHash(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.ParseInt(trimQuery(r, "/rest/blockhashbyheight/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid height", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if height < 0 || height >= int64(len(s.blocks)) {
+		http.Error(w, "Block height out of range", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, btc.BlockHash{Blockhash: s.blocks[height].Hash})
+}
+
+func (s *Server) handleBlockInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	block, ok := s.getBlock(trimQuery(r, "/rest/block/notxdetails/"))
+	if ok == false {
+		http.Error(w, "Block not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"hash":              block.Hash,
+		"confirmations":     block.Confirmations,
+		"height":            block.Height,
+		"nTx":               block.Ntx,
+		"tx":                block.GetTxIDs(),
+		"time":              block.Time,
+		"mediantime":        block.Mediantime,
+		"previousblockhash": block.Previousblockhash,
+	})
+}
+
+func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	block, ok := s.getBlock(trimQuery(r, "/rest/block/"))
+	if ok == false {
+		http.Error(w, "Block not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, block)
+}
+
+func (s *Server) handleTx(w http.ResponseWriter, r *http.Request) {
+	txid := trimQuery(r, "/rest/tx/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, ok := s.mempool[txid]
+	if ok == true {
+		writeJSON(w, tx)
+		return
+	}
+	for _, block := range s.blocks {
+		for _, tx := range block.Txs {
+			if tx.Txid == txid {
+				writeJSON(w, tx)
+				return
+			}
+		}
+	}
+	http.Error(w, txid+" not found", http.StatusNotFound)
+}
+
+func (s *Server) handleMempool(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, s.pool)
+}
+
+// trimQuery returns the path after prefix without the .json extension
+func trimQuery(r *http.Request, prefix string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), ".json")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func hash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "_")))
+	return hex.EncodeToString(sum[:])
+}
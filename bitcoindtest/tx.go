@@ -0,0 +1,66 @@
+package bitcoindtest
+
+import (
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/SwingbyProtocol/tx-indexer/btc"
+)
+
+var txCount int64
+
+// NewTx builds a tx with a unique txid. Coinbase inputs have an empty Txid.
+func NewTx(vins []*btc.Vin, vouts ...*btc.Vout) *btc.Tx {
+	n := atomic.AddInt64(&txCount, 1)
+	parts := []string{strconv.FormatInt(n, 10)}
+	for _, vin := range vins {
+		parts = append(parts, vin.Txid, strconv.Itoa(vin.Vout))
+	}
+	for i, vout := range vouts {
+		vout.N = i
+		parts = append(parts, vout.Scriptpubkey.Addresses...)
+	}
+	txid := hash(parts...)
+	return &btc.Tx{
+		Txid:    txid,
+		Hash:    txid,
+		Version: 2,
+		Vin:     vins,
+		Vout:    vouts,
+	}
+}
+
+// Coinbase is the input of a coinbase tx, with the height pushed like BIP34
+func Coinbase(height int64) *btc.Vin {
+	script := []byte{4, byte(height), byte(height >> 8), byte(height >> 16), byte(height >> 24)}
+	return &btc.Vin{Coinbase: hex.EncodeToString(script), Sequence: 0xffffffff}
+}
+
+// Input spends output n of txid
+func Input(txid string, n int) *btc.Vin {
+	return &btc.Vin{Txid: txid, Vout: n, Sequence: 0xfffffffd}
+}
+
+// Output pays value satoshis to address
+func Output(address string, value int64) *btc.Vout {
+	script, err := btc.DecodeAddress(address)
+	if err != nil {
+		panic(err)
+	}
+	vout := OutputScript(script, value)
+	// the params only select the encoding of the address
+	vout.Scriptpubkey.Address = address
+	vout.Scriptpubkey.Addresses = []string{address}
+	return vout
+}
+
+// OutputScript pays value satoshis to a raw script, e.g. bare multisig or
+// OP_RETURN
+func OutputScript(script []byte, value int64) *btc.Vout {
+	return &btc.Vout{
+		Value:        value,
+		Txs:          []string{},
+		Scriptpubkey: btc.NewScriptPubkey(script, btc.MainNetParams),
+	}
+}
@@ -0,0 +1,232 @@
+package btc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+const (
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	bech32Charset  = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32Const    = 1
+	bech32mConst   = 0x2bc830a3
+)
+
+func EncodeBase58Check(version byte, payload []byte) string {
+	data := append([]byte{version}, payload...)
+	checksum := doubleSha256(data)
+	data = append(data, checksum[:4]...)
+	return encodeBase58(data)
+}
+
+func encodeBase58(data []byte) string {
+	num := new(big.Int).SetBytes(data)
+	base := big.NewInt(58)
+	mod := new(big.Int)
+	res := []byte{}
+	for num.Sign() > 0 {
+		num.DivMod(num, base, mod)
+		res = append(res, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		res = append(res, base58Alphabet[0])
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return string(res)
+}
+
+func decodeBase58(s string) ([]byte, error) {
+	num := new(big.Int)
+	base := big.NewInt(58)
+	for i := 0; i < len(s); i++ {
+		index := strings.IndexByte(base58Alphabet, s[i])
+		if index < 0 {
+			return nil, errors.New("invalid base58 character")
+		}
+		num.Mul(num, base)
+		num.Add(num, big.NewInt(int64(index)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), num.Bytes()...), nil
+}
+
+// DecodeAddress returns the scriptPubKey paid by a base58 or bech32 address
+// of any of the chains
+func DecodeAddress(addr string) ([]byte, error) {
+	script, err := decodeSegwitAddress(addr)
+	if err == nil {
+		return script, nil
+	}
+	data, err := decodeBase58(addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 25 || bytes.Equal(doubleSha256(data[:21])[:4], data[21:]) == false {
+		return nil, errors.New("invalid address checksum")
+	}
+	hash := data[1:21]
+	switch data[0] {
+	case MainNetParams.PubKeyHashPrefix, TestNetParams.PubKeyHashPrefix:
+		script := append([]byte{opDup, opHash160, 20}, hash...)
+		return append(script, opEqualVerify, opCheckSig), nil
+	case MainNetParams.ScriptHashPrefix, TestNetParams.ScriptHashPrefix:
+		script := append([]byte{opHash160, 20}, hash...)
+		return append(script, opEqual), nil
+	}
+	return nil, errors.New("unknown address version")
+}
+
+// decodeSegwitAddress checks the address as BIP173 and BIP350 do: the
+// human readable part of a known chain, at most 90 characters in a single
+// case, and a witness program of the length of its version
+func decodeSegwitAddress(addr string) ([]byte, error) {
+	if len(addr) > 90 {
+		return nil, errors.New("bech32 address is too long")
+	}
+	lower := strings.ToLower(addr)
+	if addr != lower && addr != strings.ToUpper(addr) {
+		return nil, errors.New("bech32 address is mixed case")
+	}
+	addr = lower
+	pos := strings.LastIndexByte(addr, '1')
+	if pos < 1 || len(addr)-pos < 8 {
+		return nil, errors.New("invalid bech32 separator")
+	}
+	hrp := addr[:pos]
+	if hrp != MainNetParams.Bech32HRP && hrp != TestNetParams.Bech32HRP && hrp != RegTestParams.Bech32HRP {
+		return nil, errors.New("unknown bech32 prefix " + hrp)
+	}
+	data := []byte{}
+	for i := pos + 1; i < len(addr); i++ {
+		index := strings.IndexByte(bech32Charset, addr[i])
+		if index < 0 {
+			return nil, errors.New("invalid bech32 character")
+		}
+		data = append(data, byte(index))
+	}
+	version := int(data[0])
+	spec := bech32Const
+	if version > 0 {
+		spec = bech32mConst
+	}
+	if bech32Polymod(append(bech32HrpExpand(hrp), data...)) != spec {
+		return nil, errors.New("invalid bech32 checksum")
+	}
+	program, err := convertBits(data[1:len(data)-6], 5, 8, false)
+	if err != nil {
+		return nil, err
+	}
+	if version > 16 || len(program) < 2 || len(program) > 40 {
+		return nil, errors.New("invalid witness program")
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return nil, errors.New("invalid witness v0 program length")
+	}
+	op := byte(0)
+	if version > 0 {
+		op = byte(op1 + version - 1)
+	}
+	return append([]byte{op, byte(len(program))}, program...), nil
+}
+
+// EncodeSegwitAddress encodes a witness program with bech32 (v0) or bech32m (v1+)
+func EncodeSegwitAddress(hrp string, version int, program []byte) (string, error) {
+	conv, err := convertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	data := append([]byte{byte(version)}, conv...)
+	spec := bech32Const
+	if version > 0 {
+		spec = bech32mConst
+	}
+	checksum := bech32Checksum(hrp, data, spec)
+	sb := strings.Builder{}
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, b := range append(data, checksum...) {
+		sb.WriteByte(bech32Charset[b])
+	}
+	return sb.String(), nil
+}
+
+func bech32Polymod(values []byte) int {
+	gen := []int{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := 1
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ int(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	res := []byte{}
+	for i := 0; i < len(hrp); i++ {
+		res = append(res, hrp[i]>>5)
+	}
+	res = append(res, 0)
+	for i := 0; i < len(hrp); i++ {
+		res = append(res, hrp[i]&31)
+	}
+	return res
+}
+
+func bech32Checksum(hrp string, data []byte, spec int) []byte {
+	values := append(bech32HrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(values) ^ spec
+	res := make([]byte, 6)
+	for i := 0; i < 6; i++ {
+		res[i] = byte((mod >> uint(5*(5-i))) & 31)
+	}
+	return res
+}
+
+func convertBits(data []byte, from uint, to uint, pad bool) ([]byte, error) {
+	acc := 0
+	bits := uint(0)
+	res := []byte{}
+	maxv := (1 << to) - 1
+	for _, b := range data {
+		if int(b)>>from != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<from | int(b)
+		bits += from
+		for bits >= to {
+			bits -= to
+			res = append(res, byte((acc>>bits)&maxv))
+		}
+	}
+	if pad == true {
+		if bits > 0 {
+			res = append(res, byte((acc<<(to-bits))&maxv))
+		}
+	} else if bits >= from || (acc<<(to-bits))&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return res, nil
+}
+
+func doubleSha256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
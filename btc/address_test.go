@@ -0,0 +1,81 @@
+package btc
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDecodeSegwitAddress(t *testing.T) {
+	// test vectors of BIP173 and BIP350
+	valid := map[string]string{
+		"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4":                                 "0014751e76e8199196d454941c45d1b3a323f1433bd6",
+		"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7":             "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262",
+		"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y": "5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6",
+		"BC1SW50QGDZ25J": "6002751e",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0": "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+	}
+	for addr, want := range valid {
+		script, err := decodeSegwitAddress(addr)
+		if err != nil {
+			t.Errorf("%s: %v", addr, err)
+			continue
+		}
+		if hex.EncodeToString(script) != want {
+			t.Errorf("%s: script %x, want %s", addr, script, want)
+		}
+	}
+	invalid := []string{
+		// unknown human readable part
+		"tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut",
+		// mixed case
+		"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sL5k7",
+		// invalid program length for witness version 0
+		"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P",
+		// bech32 instead of bech32m for witness version 1
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd",
+		// invalid checksum
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5",
+		// longer than 90 characters
+		"bc1q" + strings.Repeat("q", 90),
+	}
+	for _, addr := range invalid {
+		_, err := decodeSegwitAddress(addr)
+		if err == nil {
+			t.Errorf("%s is decoded", addr)
+		}
+	}
+}
+
+// TestP2PKAlias checks that a P2PK output is found by its script hash and by
+// the P2PKH address bitcoind before 22.0 reported for it
+func TestP2PKAlias(t *testing.T) {
+	p2pk := "12c6DSiU4Rq3P4ZxziKxzrL5LmMBrzjrJX"
+	node := newTestNode()
+	coinbase, _ := loadTxFixture(t, "getrawtransaction_coinbase_v21.json")
+	node.addBlock(&Block{Hash: "block5", Height: 5, Txs: []*Tx{coinbase}})
+	scripthash := coinbase.Vout[0].Scriptpubkey.Scripthash()
+	for _, addr := range []string{p2pk, scripthash, ToScripthash(p2pk)} {
+		txs, err := node.index.GetIns(addr, node.storage)
+		if err != nil || len(txs) != 1 || txs[0].Txid != coinbaseTxid {
+			t.Errorf("txs of %s %v %v", addr, txs, err)
+		}
+		utxos, err := node.index.GetUtxos(addr, node.storage, 104)
+		if err != nil || len(utxos) != 1 || utxos[0].ValueSat != 50*satPerBTC {
+			t.Errorf("utxos of %s %v %v", addr, utxos, err)
+		}
+	}
+	keys := watchKeys(coinbase)
+	if checkExist(p2pk, keys) == false || checkExist(ToScripthash(p2pk), keys) == false || checkExist(scripthash, keys) == false {
+		t.Errorf("watch keys %v", keys)
+	}
+
+	// bitcoind 22.0+ reports no address, so only the script hash finds it
+	node = newTestNode()
+	coinbase, _ = loadTxFixture(t, "getrawtransaction_coinbase_v23.json")
+	node.addBlock(&Block{Hash: "block5", Height: 5, Txs: []*Tx{coinbase}})
+	txs, err := node.index.GetIns(scripthash, node.storage)
+	if err != nil || len(txs) != 1 {
+		t.Errorf("txs of %s %v %v", scripthash, txs, err)
+	}
+}
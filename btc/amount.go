@@ -0,0 +1,195 @@
+package btc
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const satPerBTC = 100000000
+
+// FormatBTC formats satoshis as a BTC amount without trailing zeros
+func FormatBTC(sat int64) string {
+	sign := ""
+	if sat < 0 {
+		sign = "-"
+		sat = -sat
+	}
+	frac := strings.TrimRight(strconv.FormatInt(satPerBTC+sat%satPerBTC, 10)[1:], "0")
+	if frac == "" {
+		return sign + strconv.FormatInt(sat/satPerBTC, 10)
+	}
+	return sign + strconv.FormatInt(sat/satPerBTC, 10) + "." + frac
+}
+
+// ParseBTC parses a BTC amount such as the json numbers of bitcoind into
+// satoshis without going through float64. Numbers with an exponent are
+// rounded to the nearest satoshi.
+func ParseBTC(s string) (int64, error) {
+	s = strings.Trim(s, "\"")
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, err
+		}
+		sat := math.Round(f * satPerBTC)
+		if math.Abs(sat) >= math.MaxInt64 {
+			return 0, errors.New("amount is out of range " + s)
+		}
+		return int64(sat), nil
+	}
+	negative := strings.HasPrefix(s, "-")
+	parts := strings.SplitN(strings.TrimPrefix(s, "-"), ".", 2)
+	if isDigits(parts[0]) == false || (len(parts) == 2 && isDigits(parts[1]) == false) {
+		return 0, errors.New("invalid amount " + s)
+	}
+	whole, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || whole > (math.MaxInt64-satPerBTC)/satPerBTC {
+		return 0, errors.New("amount is out of range " + s)
+	}
+	frac := int64(0)
+	if len(parts) == 2 {
+		digits := strings.TrimRight(parts[1], "0")
+		if len(digits) > 8 {
+			return 0, errors.New("amount has more than 8 decimals " + s)
+		}
+		if digits != "" {
+			frac, err = strconv.ParseInt(digits+strings.Repeat("0", 8-len(digits)), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	sat := whole*satPerBTC + frac
+	if negative == true {
+		sat = -sat
+	}
+	return sat, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON writes the value both as a BTC string and in satoshis
+func (vout *Vout) MarshalJSON() ([]byte, error) {
+	type alias Vout
+	return json.Marshal(&struct {
+		Value    string `json:"value"`
+		ValueSat int64  `json:"valueSat"`
+		*alias
+	}{FormatBTC(vout.Value), vout.Value, (*alias)(vout)})
+}
+
+// UnmarshalJSON reads valueSat, or the BTC value of bitcoind
+func (vout *Vout) UnmarshalJSON(data []byte) error {
+	type alias Vout
+	aux := &struct {
+		Value    json.RawMessage `json:"value"`
+		ValueSat *int64          `json:"valueSat"`
+		*alias
+	}{alias: (*alias)(vout)}
+	err := json.Unmarshal(data, aux)
+	if err != nil {
+		return err
+	}
+	value, err := readAmount(aux.Value, aux.ValueSat)
+	if err != nil {
+		return err
+	}
+	if value != nil {
+		vout.Value = *value
+	}
+	return nil
+}
+
+// MarshalJSON writes the prevout value, when it is known, like Vout
+func (vin *Vin) MarshalJSON() ([]byte, error) {
+	type alias Vin
+	aux := &struct {
+		Value    *string `json:"value,omitempty"`
+		ValueSat *int64  `json:"valueSat,omitempty"`
+		*alias
+	}{alias: (*alias)(vin)}
+	if vin.Value != nil {
+		value := FormatBTC(*vin.Value)
+		aux.Value = &value
+		aux.ValueSat = vin.Value
+	}
+	return json.Marshal(aux)
+}
+
+func (vin *Vin) UnmarshalJSON(data []byte) error {
+	type alias Vin
+	aux := &struct {
+		Value    json.RawMessage `json:"value"`
+		ValueSat *int64          `json:"valueSat"`
+		*alias
+	}{alias: (*alias)(vin)}
+	err := json.Unmarshal(data, aux)
+	if err != nil {
+		return err
+	}
+	vin.Value, err = readAmount(aux.Value, aux.ValueSat)
+	return err
+}
+
+// MarshalJSON writes the fee, when it is known, like Vin.Value. confirms
+// is the deprecated field which held the block height, and still does for
+// older clients.
+func (tx *Tx) MarshalJSON() ([]byte, error) {
+	type alias Tx
+	aux := &struct {
+		Fee      *string `json:"fee,omitempty"`
+		FeeSat   *int64  `json:"feeSat,omitempty"`
+		Confirms int64   `json:"confirms"`
+		*alias
+	}{Confirms: tx.BlockHeight, alias: (*alias)(tx)}
+	if tx.Fee != nil {
+		fee := FormatBTC(*tx.Fee)
+		aux.Fee = &fee
+		aux.FeeSat = tx.Fee
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON reads feeSat, or the BTC fee which getblock of bitcoind
+// 0.21+ reports for the txs of a block
+func (tx *Tx) UnmarshalJSON(data []byte) error {
+	type alias Tx
+	aux := &struct {
+		Fee    json.RawMessage `json:"fee"`
+		FeeSat *int64          `json:"feeSat"`
+		*alias
+	}{alias: (*alias)(tx)}
+	err := json.Unmarshal(data, aux)
+	if err != nil {
+		return err
+	}
+	tx.Fee, err = readAmount(aux.Fee, aux.FeeSat)
+	return err
+}
+
+func readAmount(value json.RawMessage, valueSat *int64) (*int64, error) {
+	if valueSat != nil {
+		return valueSat, nil
+	}
+	if len(value) == 0 || string(value) == "null" {
+		return nil, nil
+	}
+	sat, err := ParseBTC(string(value))
+	if err != nil {
+		return nil, err
+	}
+	return &sat, nil
+}
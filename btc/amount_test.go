@@ -0,0 +1,165 @@
+package btc
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// the most satoshis there will ever be
+const maxSupply = 2099999997690000
+
+func TestParseBTC(t *testing.T) {
+	tests := []struct {
+		in  string
+		sat int64
+	}{
+		{"0", 0},
+		{"0.00000000", 0},
+		{"1", 100000000},
+		{"0.00000001", 1},
+		{"0.29", 29000000},
+		{"1.1", 110000000},
+		{"50.00000000", 5000000000},
+		{"0.100000000", 10000000},
+		{"-0.5", -50000000},
+		{"-0.00000001", -1},
+		{"21000000", 21000000 * satPerBTC},
+		{"20999999.9769", maxSupply},
+		{`"0.00001410"`, 1410},
+		// exponents are rounded to the nearest satoshi
+		{"1e-8", 1},
+		{"1e-9", 0},
+		{"2.9e-8", 3},
+		{"-2.9e-8", -3},
+		{"2.1e7", 21000000 * satPerBTC},
+		{"1.23456789E1", 1234567890},
+	}
+	for _, test := range tests {
+		sat, err := ParseBTC(test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+			continue
+		}
+		if sat != test.sat {
+			t.Errorf("%s: %d, want %d", test.in, sat, test.sat)
+		}
+	}
+}
+
+func TestParseBTCErrors(t *testing.T) {
+	tests := []string{
+		"",
+		".",
+		"-",
+		"1.",
+		".5",
+		" 1",
+		"+1",
+		"--1",
+		"1.-5",
+		"1.+5",
+		"1.2.3",
+		"1,5",
+		"0x10",
+		"abc",
+		"NaN",
+		"1e400",
+		"1e12",
+		// more than 8 decimals
+		"0.000000001",
+		"0.123456789",
+		// more than int64 satoshis
+		"92233720369",
+		"100000000000",
+	}
+	for _, in := range tests {
+		sat, err := ParseBTC(in)
+		if err == nil {
+			t.Errorf("%q: %d without an error", in, sat)
+		}
+	}
+}
+
+func TestFormatBTC(t *testing.T) {
+	tests := []struct {
+		sat int64
+		out string
+	}{
+		{0, "0"},
+		{1, "0.00000001"},
+		{10, "0.0000001"},
+		{29000000, "0.29"},
+		{100000000, "1"},
+		{110000000, "1.1"},
+		{5000000000, "50"},
+		{-1, "-0.00000001"},
+		{-150000000, "-1.5"},
+		{21000000 * satPerBTC, "21000000"},
+		{maxSupply, "20999999.9769"},
+	}
+	for _, test := range tests {
+		out := FormatBTC(test.sat)
+		if out != test.out {
+			t.Errorf("%d: %s, want %s", test.sat, out, test.out)
+		}
+		sat, err := ParseBTC(out)
+		if err != nil || sat != test.sat {
+			t.Errorf("%s: parsed back to %d %v", out, sat, err)
+		}
+	}
+}
+
+func TestAmountMarshalRoundTrip(t *testing.T) {
+	values := []int64{0, 1, 29000000, 5000000000, maxSupply}
+	for _, value := range values {
+		vout := &Vout{Value: value, Txs: []string{}, Scriptpubkey: &ScriptPubkey{Hex: "6a", Addresses: []string{}}}
+		data, err := json.Marshal(vout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := &Vout{}
+		err = json.Unmarshal(data, res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(res, vout) == false {
+			t.Errorf("vout %s read back as %+v", data, res)
+		}
+		v := value
+		vin := &Vin{Txid: coinbaseTxid, Value: &v}
+		data, err = json.Marshal(vin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resVin := &Vin{}
+		err = json.Unmarshal(data, resVin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(resVin, vin) == false {
+			t.Errorf("vin %s read back as %+v", data, resVin)
+		}
+	}
+	// bitcoind only writes value, in BTC
+	vout := &Vout{}
+	err := json.Unmarshal([]byte(`{"value":20999999.97690000,"n":1}`), vout)
+	if err != nil || vout.Value != maxSupply || vout.N != 1 {
+		t.Errorf("bitcoind vout %+v %v", vout, err)
+	}
+	// valueSat wins over value
+	err = json.Unmarshal([]byte(`{"value":"1","valueSat":5}`), vout)
+	if err != nil || vout.Value != 5 {
+		t.Errorf("vout value %d %v", vout.Value, err)
+	}
+	// an unresolved prevout value stays unknown
+	vin := &Vin{}
+	err = json.Unmarshal([]byte(`{"txid":"`+coinbaseTxid+`","vout":0}`), vin)
+	if err != nil || vin.Value != nil {
+		t.Errorf("vin value %v %v", vin.Value, err)
+	}
+	err = json.Unmarshal([]byte(`{"value":"1.-5"}`), vout)
+	if err == nil {
+		t.Error("malformed value is read")
+	}
+}
@@ -0,0 +1,147 @@
+package btc
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// Balance of an address in satoshis. Unconfirmed is the change made by
+// mempool txs and can be negative. Immature are the coinbase outputs which
+// cannot be spent yet, they are not part of Confirmed.
+type Balance struct {
+	Confirmed   int64 `json:"confirmed"`
+	Unconfirmed int64 `json:"unconfirmed"`
+	Immature    int64 `json:"immature"`
+}
+
+type Utxo struct {
+	Txid          string `json:"txid"`
+	Vout          int    `json:"vout"`
+	Value         string `json:"value"`
+	ValueSat      int64  `json:"valueSat"`
+	Height        int64  `json:"height"`
+	Confirmations int64  `json:"confirmations"`
+	IsCoinbase    bool   `json:"isCoinbase"`
+	Mature        bool   `json:"mature"`
+}
+
+// IsActive reports whether the tx is confirmed or may still be
+func (tx *Tx) IsActive() bool {
+	return tx.Status != TxDropped && tx.Status != TxReplaced && tx.Status != TxConflicted
+}
+
+// GetUtxos returns the outputs to addr which are not spent by an active tx.
+// Only the txs in the index window are known.
+func (i *Index) GetUtxos(addr string, storage Storage, tip int64) ([]*Utxo, error) {
+	utxos, _, err := i.getUtxos(addr, storage, tip)
+	return utxos, err
+}
+
+func (i *Index) GetBalance(addr string, storage Storage, tip int64) (*Balance, error) {
+	utxos, confirmed, err := i.getUtxos(addr, storage, tip)
+	if err != nil {
+		return nil, err
+	}
+	balance := &Balance{Confirmed: confirmed}
+	for _, utxo := range utxos {
+		if utxo.Mature == false {
+			balance.Immature += utxo.ValueSat
+			continue
+		}
+		balance.Unconfirmed += utxo.ValueSat
+	}
+	balance.Unconfirmed -= confirmed
+	return balance, nil
+}
+
+// getUtxos also returns the confirmed balance, which counts the mature
+// outputs of confirmed txs that are not spent by a confirmed tx. An address
+// which is not indexed is an error like with GetIns.
+func (i *Index) getUtxos(addr string, storage Storage, tip int64) ([]*Utxo, int64, error) {
+	utxos := []*Utxo{}
+	confirmed := int64(0)
+	txs, err := i.GetIns(addr, storage)
+	if err != nil {
+		return nil, 0, err
+	}
+	scripthash := ToScripthash(addr)
+	for _, tx := range txs {
+		if tx.IsActive() == false {
+			continue
+		}
+		tx.UpdateConfirmations(tip)
+		for n, vout := range tx.Vout {
+			if vout.Scriptpubkey.HasScripthash(scripthash) == false {
+				continue
+			}
+			spent, spentConfirmed := getSpender(storage, tx.Txid+"_"+strconv.Itoa(n))
+			if tx.BlockHeight > 0 && tx.Mature == true && spentConfirmed == false {
+				confirmed += vout.Value
+			}
+			if spent == true {
+				continue
+			}
+			utxo := &Utxo{
+				Txid:          tx.Txid,
+				Vout:          n,
+				Value:         FormatBTC(vout.Value),
+				ValueSat:      vout.Value,
+				Height:        tx.BlockHeight,
+				Confirmations: tx.Confirmations,
+				IsCoinbase:    tx.IsCoinbase,
+				Mature:        tx.Mature,
+			}
+			utxos = append(utxos, utxo)
+		}
+	}
+	return utxos, confirmed, nil
+}
+
+// getSpender reports whether an active tx spends key, and whether the
+// spending tx is confirmed. A spending tx which cannot be loaded is not
+// known to be confirmed, so it counts as pending.
+func getSpender(storage Storage, key string) (bool, bool) {
+	spents, err := storage.GetSpents(key)
+	if err != nil {
+		return false, false
+	}
+	spent := false
+	for _, txid := range spents {
+		tx, err := storage.GetTx(txid)
+		if err != nil {
+			spent = true
+			continue
+		}
+		if tx.BlockHeight > 0 {
+			return true, true
+		}
+		if tx.IsActive() == true {
+			spent = true
+		}
+	}
+	return spent, false
+}
+
+func (node *Node) GetBalance(w rest.ResponseWriter, r *rest.Request) {
+	address := r.PathParam("address")
+	balance, err := node.index.GetBalance(address, node.storage, node.blockchain.GetLatestBlock())
+	if err != nil {
+		res500(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.WriteJson(balance)
+}
+
+func (node *Node) GetUtxos(w rest.ResponseWriter, r *rest.Request) {
+	address := r.PathParam("address")
+	utxos, err := node.index.GetUtxos(address, node.storage, node.blockchain.GetLatestBlock())
+	if err != nil {
+		res500(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.WriteJson(utxos)
+}
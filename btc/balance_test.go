@@ -0,0 +1,85 @@
+package btc
+
+import (
+	"testing"
+)
+
+// testPayment returns a tx paying value to the script of spk
+func testPayment(txid string, spk *ScriptPubkey, value int64) *Tx {
+	tx := testSpend(txid, coinbaseTxid, 1, 0xffffffff)
+	tx.Vout = []*Vout{{Value: value, Scriptpubkey: spk}}
+	return tx
+}
+
+func assertBalance(t *testing.T, node *Node, addr string, tip int64, want Balance) {
+	t.Helper()
+	balance, err := node.index.GetBalance(addr, node.storage, tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *balance != want {
+		t.Errorf("balance at %d %+v, want %+v", tip, *balance, want)
+	}
+}
+
+func TestBalance(t *testing.T) {
+	node := newTestNode()
+	parent, _ := loadTxFixture(t, "getrawtransaction_spend_v23.json")
+	spk := parent.Vout[0].Scriptpubkey
+	addr := spk.Address
+	node.addBlock(&Block{Hash: "block5", Height: 5, Txs: []*Tx{parent}})
+	// a coinbase output matures after 100 confirmations
+	coinbase := &Tx{
+		Txid: "cb",
+		Vin:  []*Vin{{Coinbase: "04ffff001d0104", Sequence: 0xffffffff}},
+		Vout: []*Vout{{Value: 50 * satPerBTC, Scriptpubkey: spk}},
+	}
+	node.addBlock(&Block{Hash: "block8", Height: 8, Txs: []*Tx{coinbase}})
+	assertBalance(t, node, addr, 10, Balance{Confirmed: 10 * satPerBTC, Immature: 50 * satPerBTC})
+	assertBalance(t, node, addr, 107, Balance{Confirmed: 60 * satPerBTC})
+
+	// a mempool payment is unconfirmed, and a mempool spend takes the
+	// output from the confirmed balance
+	node.addTx(testPayment("u", spk, satPerBTC))
+	node.addTx(testSpend("s", spendTxid, 0, 0xffffffff))
+	assertBalance(t, node, addr, 10, Balance{Confirmed: 10 * satPerBTC, Unconfirmed: -9 * satPerBTC, Immature: 50 * satPerBTC})
+	utxos, err := node.index.GetUtxos(addr, node.storage, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Utxo{
+		"u":  {Txid: "u", Value: "1", ValueSat: satPerBTC, Mature: true},
+		"cb": {Txid: "cb", Value: "50", ValueSat: 50 * satPerBTC, Height: 8, Confirmations: 3, IsCoinbase: true},
+	}
+	if len(utxos) != len(want) {
+		t.Fatalf("utxos %+v", utxos)
+	}
+	for _, utxo := range utxos {
+		if *utxo != want[utxo.Txid] {
+			t.Errorf("utxo %+v, want %+v", *utxo, want[utxo.Txid])
+		}
+	}
+
+	// a spending tx which cannot be loaded counts as pending
+	node.storage.DeleteTx("s")
+	assertBalance(t, node, addr, 10, Balance{Confirmed: 10 * satPerBTC, Unconfirmed: -9 * satPerBTC, Immature: 50 * satPerBTC})
+
+	// a dropped spend returns the output
+	dropped := testSpend("s", spendTxid, 0, 0xffffffff)
+	dropped.Status = TxDropped
+	node.storage.UpdateTx(dropped)
+	assertBalance(t, node, addr, 10, Balance{Confirmed: 10 * satPerBTC, Unconfirmed: satPerBTC, Immature: 50 * satPerBTC})
+
+	// once both are mined the spent output is gone
+	node.addBlock(&Block{Hash: "block11", Height: 11, Txs: []*Tx{testPayment("u", spk, satPerBTC), testSpend("s", spendTxid, 0, 0xffffffff)}})
+	assertBalance(t, node, addr, 11, Balance{Confirmed: satPerBTC, Immature: 50 * satPerBTC})
+
+	_, err = node.index.GetBalance("1cMh228HTCiwS8ZsaakH8A8wze1JR5ZsP", node.storage, 11)
+	if err == nil {
+		t.Error("balance of an address which is not indexed")
+	}
+	_, err = node.index.GetUtxos("1cMh228HTCiwS8ZsaakH8A8wze1JR5ZsP", node.storage, 11)
+	if err == nil {
+		t.Error("utxos of an address which is not indexed")
+	}
+}
@@ -19,7 +19,7 @@ func (block *Block) GetTxIDs() []string {
 	return ids
 }
 
-func (block *Block) UpdateTxs(storage *Storage) []*Tx {
+func (block *Block) UpdateTxs(storage Storage) []*Tx {
 	newTxs := []*Tx{}
 	for _, tx := range block.Txs {
 		loadTx, err := storage.GetTx(tx.Txid)
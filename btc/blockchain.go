@@ -2,12 +2,16 @@ package btc
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/SwingbyProtocol/tx-indexer/resolver"
 	log "github.com/sirupsen/logrus"
 )
 
+// reorgWindow is the number of recent headers kept to detect chain reorganizations
+const reorgWindow = 64
+
 type ChainInfo struct {
 	Chain         string `json:"chain"`
 	Blocks        int64  `json:"blocks"`
@@ -16,34 +20,81 @@ type ChainInfo struct {
 }
 
 type BlockChain struct {
-	mempool        *Mempool
-	resolver       *resolver.Resolver
-	latestblock    int64
-	pruneblocks    int
-	blocktimes     []int64
-	nextblockcount int64
-	waitchan       chan Block
-	tasks          []*Task
+	mempool      *Mempool
+	source       Source
+	latestblock  int64
+	pruneblocks  int
+	startheight  int64
+	workers      int
+	headers      []*Header
+	pending      map[string]*Block
+	waitchan     chan Block
+	rollbackchan chan Header
+	notifychan   chan bool
+	tasks        []*Task
+	// guards the headers, the tasks and the pending blocks, which are shared
+	// by the tip poller, the block loader and the readers of the tip
+	mu sync.RWMutex
+}
+
+type Header struct {
+	Hash              string
+	Height            int64
+	Previousblockhash string
+	Time              int64
+	Txids             []string
 }
 
 type Task struct {
 	BlockHash string
+	Height    int64
 	Errors    int
+	// roll back to the known header BlockHash instead of loading a block
+	Rollback bool
+}
+
+type BlockHash struct {
+	Blockhash string `json:"blockhash"`
 }
 
-func NewBlockchain(uri string, pruneblocks int) *BlockChain {
+func NewBlockchain(conf *Config) *BlockChain {
+	workers := conf.BackfillWorkers
+	if workers < 1 {
+		workers = 1
+	}
 	bc := &BlockChain{
-		resolver:    resolver.NewResolver(uri),
-		mempool:     NewMempool(uri),
-		pruneblocks: pruneblocks,
-		waitchan:    make(chan Block),
+		source:       conf.Source,
+		mempool:      NewMempool(conf.Source, conf.MempoolWorkers),
+		pruneblocks:  conf.PruneBlocks,
+		startheight:  conf.StartHeight,
+		workers:      workers,
+		pending:      make(map[string]*Block),
+		waitchan:     make(chan Block),
+		rollbackchan: make(chan Header),
+		notifychan:   make(chan bool, 1),
 	}
 	return bc
 }
 
+// Restore resumes the header chain from a checkpoint. Blocks mined after the
+// checkpoint are caught up by height on the next sync.
+func (b *BlockChain) Restore(cp *Checkpoint) {
+	b.mu.Lock()
+	// headers after the checkpoint block were not indexed
+	b.headers = headersTo(cp.Headers, cp.Hash)
+	b.latestblock = cp.Height
+	b.mu.Unlock()
+	log.Infof("Checkpoint Block# %d Restored %s", cp.Height, cp.Hash)
+}
+
 func (b *BlockChain) StartSync(t time.Duration) {
 	go b.doLoadNewBlocks(t)
-	go b.doLoadBlock(3 * time.Second)
+	// queued tasks are checked at least every 3 seconds
+	delay := 3 * time.Second
+	if t < delay {
+		delay = t
+	}
+	go b.doLoadBlock(delay)
 }
 
 func (b *BlockChain) StartMemSync(t time.Duration) {
@@ -55,92 +106,357 @@ func (b *BlockChain) doLoadNewBlocks(t time.Duration) {
 	if err != nil {
 		log.Info(err)
 	}
-	time.Sleep(t)
+	select {
+	case <-time.After(t):
+	case <-b.notifychan:
+	}
 	go b.doLoadNewBlocks(t)
 	return
 }
 
 func (b *BlockChain) doLoadBlock(t time.Duration) {
-	err := b.getBlock()
+	err := b.getBlocks()
 	if err != nil {
 		log.Info(err)
 	}
-	time.Sleep(t)
+	// keep catching up without waiting while tasks are queued
+	if err != nil || b.taskCount() == 0 {
+		time.Sleep(t)
+	}
 	go b.doLoadBlock(t)
 	return
 }
 
+// Notify wakes up the block sync without waiting for the next poll
+func (b *BlockChain) Notify() {
+	select {
+	case b.notifychan <- true:
+	default:
+	}
+}
+
 func (b *BlockChain) loadNewBlocks() error {
-	info := ChainInfo{}
-	err := b.resolver.GetRequest("/rest/chaininfo.json", &info)
+	info, err := b.source.GetChainInfo()
 	if err != nil {
 		return err
 	}
-
-	if b.latestblock == 0 {
-		b.latestblock = info.Blocks - 1
+	tip := b.GetTip()
+	if tip != nil && tip.Hash == info.Bestblockhash {
+		return nil
 	}
-	if b.latestblock >= info.Blocks {
+	if tip == nil && b.startheight > 0 && b.startheight <= info.Blocks {
+		if info.Blocks-b.startheight >= int64(b.pruneblocks) {
+			log.Warnf("Backfill from Block# %d is longer than prune blocks %d, older history will be pruned", b.startheight, b.pruneblocks)
+		}
+		b.pushHeightTasks(b.startheight, info.Blocks)
 		return nil
 	}
-	if b.latestblock < info.Blocks {
-		b.nextblockcount = info.Blocks - b.latestblock
-		b.latestblock = info.Blocks
+	if tip != nil && info.Blocks > tip.Height {
+		b.pushHeightTasks(tip.Height+1, info.Blocks)
+		return nil
 	}
-	log.Infof("Task Block# %d Push", b.latestblock)
-	task := Task{info.Bestblockhash, 0}
+	// the chain reorged to a shorter branch which is already connected
+	rollback := tip != nil && info.Blocks < tip.Height && b.getHeader(info.Bestblockhash) != nil
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.hasTask(info.Bestblockhash) {
+		return nil
+	}
+	log.Infof("Task Block# %d Push", info.Blocks)
+	task := Task{info.Bestblockhash, 0, 0, rollback}
 	b.tasks = append(b.tasks, &task)
 	return nil
 }
 
-func (b *BlockChain) getBlock() error {
-	if len(b.tasks) == 0 {
+func (b *BlockChain) pushHeightTasks(from int64, to int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for height := from; height <= to; height++ {
+		if b.hasHeightTask(height) {
+			continue
+		}
+		log.Infof("Task Block# %d Push", height)
+		task := Task{"", height, 0, false}
+		b.tasks = append(b.tasks, &task)
+	}
+}
+
+// getBlocks fetches up to workers queued blocks concurrently and connects
+// them in queue order. A failed task and every task after it are put back
+// to the front of the queue so that blocks are never connected out of order.
+func (b *BlockChain) getBlocks() error {
+	batch := b.nextTasks()
+	if len(batch) == 0 {
+		return nil
+	}
+	if batch[0].Rollback == true {
+		header := b.getHeader(batch[0].BlockHash)
+		if header != nil {
+			b.rollbackTo(header)
+		}
 		return nil
 	}
-	task := b.tasks[0]
-	b.tasks = b.tasks[1:]
-	block := Block{}
-	err := b.resolver.GetRequest("/rest/block/"+task.BlockHash+".json", &block)
+	count := len(batch)
+	blocks := make([]*Block, count)
+	errs := make([]error, count)
+	wg := sync.WaitGroup{}
+	for i, task := range batch {
+		wg.Add(1)
+		go func(i int, task *Task) {
+			defer wg.Done()
+			blocks[i], errs[i] = b.getBlock(task)
+		}(i, task)
+	}
+	wg.Wait()
+	for i, block := range blocks {
+		if errs[i] != nil {
+			retry := []*Task{}
+			for _, task := range batch[i:] {
+				if task == batch[i] {
+					task.Errors++
+					log.Info("task errors: ", task.Errors)
+					// a block which is not found will not appear by retrying
+					if task.Errors > 8 || errors.Is(errs[i], resolver.ErrNotFound) {
+						continue
+					}
+				}
+				retry = append(retry, task)
+			}
+			b.mu.Lock()
+			b.tasks = append(retry, b.tasks...)
+			b.mu.Unlock()
+			return errs[i]
+		}
+		b.connectBlock(block)
+	}
+	return nil
+}
+
+// nextTasks pops up to workers tasks to load together. A rollback task is
+// always popped alone.
+func (b *BlockChain) nextTasks() []*Task {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	count := 0
+	for count < b.workers && count < len(b.tasks) {
+		if b.tasks[count].Rollback == true {
+			if count == 0 {
+				count = 1
+			}
+			break
+		}
+		count++
+	}
+	batch := b.tasks[:count]
+	b.tasks = b.tasks[count:]
+	return batch
+}
+
+func (b *BlockChain) getBlock(task *Task) (*Block, error) {
+	if task.BlockHash == "" {
+		hash, err := b.source.GetBlockHash(task.Height)
+		if err != nil {
+			return nil, err
+		}
+		task.BlockHash = hash
+	}
+	block, err := b.source.GetBlock(task.BlockHash)
 	if err != nil {
-		b.AddTaskWithError(task)
-		return err
+		return nil, err
 	}
 	if block.Height == 0 {
-		b.AddTaskWithError(task)
-		return errors.New("Block height is zero " + task.BlockHash)
-	}
-	b.blocktimes = append(b.blocktimes, block.Time)
-	if len(b.blocktimes) > b.pruneblocks+1 {
-		b.blocktimes = b.blocktimes[1:]
+		return nil, errors.New("Block height is zero " + task.BlockHash)
 	}
 	log.Infof("Task Block# %d Get", block.Height)
-	b.waitchan <- block
-	if b.nextblockcount <= 0 {
-		return nil
+	return block, nil
+}
+
+// connectBlock links the block to the known header chain. When the parent is
+// unknown it walks back through previousblockhash until it finds the fork
+// point, then rolls back the orphaned blocks and applies the winning branch.
+// It only runs on the block loader, which is the only writer of the headers.
+func (b *BlockChain) connectBlock(block *Block) {
+	if b.getHeader(block.Hash) != nil {
+		return
 	}
-	b.nextblockcount--
-	if b.nextblockcount > 0 {
-		task := Task{block.Previousblockhash, 0}
-		b.tasks = append(b.tasks, &task)
+	tip := b.GetTip()
+	if tip == nil || tip.Hash == block.Previousblockhash {
+		b.applyBlock(block)
+		b.applyPending(block.Hash)
+		return
+	}
+	parent := b.getHeader(block.Previousblockhash)
+	b.mu.Lock()
+	if parent == nil && block.Height > b.headers[0].Height {
+		b.pending[block.Previousblockhash] = block
+		if b.hasTask(block.Previousblockhash) == false {
+			task := Task{block.Previousblockhash, 0, 0, false}
+			b.tasks = append(b.tasks, &task)
+		}
+		b.mu.Unlock()
+		return
+	}
+	b.mu.Unlock()
+	b.rollbackTo(parent)
+	b.applyBlock(block)
+	b.applyPending(block.Hash)
+}
+
+func (b *BlockChain) applyPending(hash string) {
+	for {
+		b.mu.Lock()
+		block, ok := b.pending[hash]
+		delete(b.pending, hash)
+		if ok == false {
+			b.pending = make(map[string]*Block)
+		}
+		b.mu.Unlock()
+		if ok == false {
+			return
+		}
+		b.applyBlock(block)
+		hash = block.Hash
+	}
+}
+
+// applyBlock appends the header and then hands the block to the node. The
+// lock is not held while sending, as the node reads the tip.
+func (b *BlockChain) applyBlock(block *Block) {
+	header := &Header{
+		Hash:              block.Hash,
+		Height:            block.Height,
+		Previousblockhash: block.Previousblockhash,
+		Time:              block.Time,
+		Txids:             block.GetTxIDs(),
+	}
+	b.mu.Lock()
+	b.headers = append(b.headers, header)
+	window := reorgWindow
+	if b.pruneblocks+1 > window {
+		window = b.pruneblocks + 1
+	}
+	if len(b.headers) > window {
+		b.headers = b.headers[len(b.headers)-window:]
+	}
+	// txids are only needed to roll back blocks within the reorg window
+	if len(b.headers) > reorgWindow {
+		b.headers[len(b.headers)-reorgWindow-1].Txids = nil
+	}
+	b.latestblock = block.Height
+	b.mu.Unlock()
+	log.Infof("Block# %d Connected %s", block.Height, block.Hash)
+	b.waitchan <- *block
+}
+
+// rollbackTo disconnects every header above the parent. A nil parent means
+// the fork is deeper than the kept window, so all known headers are dropped.
+func (b *BlockChain) rollbackTo(parent *Header) {
+	for {
+		b.mu.Lock()
+		if len(b.headers) == 0 {
+			b.mu.Unlock()
+			break
+		}
+		tip := b.headers[len(b.headers)-1]
+		if parent != nil && tip.Hash == parent.Hash {
+			b.mu.Unlock()
+			break
+		}
+		b.headers = b.headers[:len(b.headers)-1]
+		b.latestblock = tip.Height - 1
+		b.mu.Unlock()
+		log.Warnf("Reorg: Block# %d Orphaned %s", tip.Height, tip.Hash)
+		b.rollbackchan <- *tip
+	}
+	b.mu.Lock()
+	b.latestblock = 0
+	if parent != nil {
+		b.latestblock = parent.Height
+	}
+	b.mu.Unlock()
+}
+
+func (b *BlockChain) getHeader(hash string) *Header {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for i := len(b.headers) - 1; i >= 0; i-- {
+		if b.headers[i].Hash == hash {
+			return b.headers[i]
+		}
 	}
 	return nil
 }
 
+// hasTask and hasHeightTask are called with the lock held
+func (b *BlockChain) hasTask(hash string) bool {
+	for _, task := range b.tasks {
+		if task.BlockHash == hash {
+			return true
+		}
+	}
+	for _, block := range b.pending {
+		if block.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BlockChain) hasHeightTask(height int64) bool {
+	for _, task := range b.tasks {
+		if task.Height == height {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BlockChain) taskCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.tasks)
+}
+
+func (b *BlockChain) GetTip() *Header {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.headers) == 0 {
+		return nil
+	}
+	return b.headers[len(b.headers)-1]
+}
+
+// GetHeaders returns a copy of the kept headers up to the block hash. The
+// headers of the blocks after it may not have been indexed yet, as a header
+// is connected before its block is handed to the node.
+func (b *BlockChain) GetHeaders(hash string) []*Header {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]*Header{}, headersTo(b.headers, hash)...)
+}
+
+// headersTo cuts headers after the header of hash. Headers without it are
+// kept as they are.
+func headersTo(headers []*Header, hash string) []*Header {
+	for i := len(headers) - 1; i >= 0; i-- {
+		if headers[i].Hash == hash {
+			return headers[:i+1]
+		}
+	}
+	return headers
+}
+
 func (b *BlockChain) GetLatestBlock() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	return b.latestblock
 }
 
 func (b *BlockChain) GetPruneBlockTime() (int64, error) {
-	if len(b.blocktimes) == b.pruneblocks+1 {
-		return b.blocktimes[0], nil
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.headers) >= b.pruneblocks+1 {
+		return b.headers[len(b.headers)-b.pruneblocks-1].Time, nil
 	}
 	return 0, errors.New("prune block is not reached")
 }
-
-func (b *BlockChain) AddTaskWithError(task *Task) {
-	task.Errors++
-	log.Info("task errors: ", task.Errors)
-	if task.Errors <= 8 {
-		b.tasks = append(b.tasks, task)
-	}
-}
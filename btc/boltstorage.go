@@ -0,0 +1,175 @@
+package btc
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	txsBucket   = []byte("txs")
+	spentBucket = []byte("spent")
+)
+
+// BoltStorage keeps txs and spent links in an embedded on-disk key-value store
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(btx *bolt.Tx) error {
+		_, err := btx.CreateBucketIfNotExists(txsBucket)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(spentBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+func (s *BoltStorage) GetTx(txid string) (*Tx, error) {
+	tx := &Tx{}
+	err := s.db.View(func(btx *bolt.Tx) error {
+		data := btx.Bucket(txsBucket).Get([]byte(txid))
+		if data == nil {
+			return errors.New("tx is not exist")
+		}
+		return json.Unmarshal(data, tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (s *BoltStorage) GetSpents(key string) ([]string, error) {
+	spents := []string{}
+	err := s.db.View(func(btx *bolt.Tx) error {
+		data := btx.Bucket(spentBucket).Get([]byte(key))
+		if data == nil {
+			return errors.New("spent is not exist")
+		}
+		return json.Unmarshal(data, &spents)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(spents) == 0 {
+		return nil, errors.New("spent tx count is zero")
+	}
+	return spents, nil
+}
+
+func (s *BoltStorage) AddSpent(key string, txid string) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(spentBucket)
+		spents := []string{}
+		data := bucket.Get([]byte(key))
+		if data != nil {
+			err := json.Unmarshal(data, &spents)
+			if err != nil {
+				return err
+			}
+		}
+		if checkExist(txid, spents) == true {
+			return errors.New("already exist")
+		}
+		spents = append(spents, txid)
+		data, err := json.Marshal(spents)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), data)
+	})
+}
+
+func (s *BoltStorage) DeleteSpent(key string) {
+	err := s.db.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket(spentBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		log.Info(err)
+	}
+}
+
+func (s *BoltStorage) DeleteTx(txid string) {
+	err := s.db.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket(txsBucket).Delete([]byte(txid))
+	})
+	if err != nil {
+		log.Info(err)
+	}
+}
+
+func (s *BoltStorage) UpdateTx(tx *Tx) {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		log.Info(err)
+		return
+	}
+	err = s.db.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket(txsBucket).Put([]byte(tx.Txid), data)
+	})
+	if err != nil {
+		log.Info(err)
+	}
+}
+
+func (s *BoltStorage) ForEachTx(f func(tx *Tx) error) error {
+	return s.db.View(func(btx *bolt.Tx) error {
+		return btx.Bucket(txsBucket).ForEach(func(k, v []byte) error {
+			tx := &Tx{}
+			err := json.Unmarshal(v, tx)
+			if err != nil {
+				return err
+			}
+			return f(tx)
+		})
+	})
+}
+
+func (s *BoltStorage) ForEachSpent(f func(key string, txids []string) error) error {
+	return s.db.View(func(btx *bolt.Tx) error {
+		return btx.Bucket(spentBucket).ForEach(func(k, v []byte) error {
+			spents := []string{}
+			err := json.Unmarshal(v, &spents)
+			if err != nil {
+				return err
+			}
+			return f(string(k), spents)
+		})
+	})
+}
+
+func (s *BoltStorage) TxCount() int {
+	return s.count(txsBucket)
+}
+
+func (s *BoltStorage) SpentCount() int {
+	return s.count(spentBucket)
+}
+
+func (s *BoltStorage) count(name []byte) int {
+	count := 0
+	s.db.View(func(btx *bolt.Tx) error {
+		count = btx.Bucket(name).Stats().KeyN
+		return nil
+	})
+	return count
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,90 @@
+package btc
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	checkpointFile     = "checkpoint.gob"
+	checkpointBlocks   = 100
+	checkpointInterval = 10 * time.Minute
+)
+
+// Checkpoint is the last fully processed block together with the index state
+// needed to resume syncing after a restart.
+type Checkpoint struct {
+	Height  int64
+	Hash    string
+	Headers []*Header
+	Lists   []*Score
+	Counter map[string]int
+	Stamps  map[string][]*Stamp
+	Sends   map[string][]*Stamp
+	Txs     map[string]*Tx
+	Spent   map[string][]string
+}
+
+func LoadCheckpoint(dir string) (*Checkpoint, error) {
+	file, err := os.Open(filepath.Join(dir, checkpointFile))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	cp := &Checkpoint{}
+	err = gob.NewDecoder(file).Decode(cp)
+	if err != nil {
+		return nil, err
+	}
+	if cp.Hash == "" {
+		return nil, errors.New("checkpoint hash is empty")
+	}
+	// gob skips empty maps
+	if cp.Counter == nil {
+		cp.Counter = make(map[string]int)
+	}
+	if cp.Stamps == nil {
+		cp.Stamps = make(map[string][]*Stamp)
+	}
+	if cp.Sends == nil {
+		cp.Sends = make(map[string][]*Stamp)
+	}
+	if cp.Txs == nil {
+		cp.Txs = make(map[string]*Tx)
+	}
+	if cp.Spent == nil {
+		cp.Spent = make(map[string][]string)
+	}
+	return cp, nil
+}
+
+func SaveCheckpoint(dir string, cp *Checkpoint) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, checkpointFile)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(tmp).Encode(cp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	// rename is atomic, so a crash never leaves a half written checkpoint
+	return os.Rename(path+".tmp", path)
+}
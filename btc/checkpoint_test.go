@@ -0,0 +1,53 @@
+package btc
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func testHeader(height int64) *Header {
+	return &Header{
+		Hash:              "block" + strconv.FormatInt(height, 10),
+		Height:            height,
+		Previousblockhash: "block" + strconv.FormatInt(height-1, 10),
+	}
+}
+
+// TestCheckpointRestart saves a checkpoint while the header of the next
+// block is connected but the block is not indexed yet, and checks that a
+// restart syncs that block again
+func TestCheckpointRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := &fakeSource{}
+	conf := &Config{Source: source, PruneBlocks: 4, DataDir: dir, Storage: NewMemStorage()}
+	node := NewNode(conf)
+	tx, _ := loadTxFixture(t, "getrawtransaction_coinbase_v23.json")
+	block := &Block{Hash: "block10", Height: 10, Previousblockhash: "block9", Txs: []*Tx{tx}}
+	node.blockchain.headers = []*Header{testHeader(9), testHeader(10), testHeader(11)}
+	node.addBlock(block)
+
+	conf.Storage = NewMemStorage()
+	node = NewNode(conf)
+	tip := node.blockchain.GetTip()
+	if tip == nil || tip.Hash != block.Hash {
+		t.Fatalf("restored tip %+v, want %s", tip, block.Hash)
+	}
+	stored, err := node.storage.GetTx(tx.Txid)
+	if err != nil || stored.BlockHeight != 10 {
+		t.Errorf("restored tx %+v %v", stored, err)
+	}
+	source.info = ChainInfo{Blocks: 11, Bestblockhash: "block11"}
+	err = node.blockchain.loadNewBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(node.blockchain.tasks) != 1 || node.blockchain.tasks[0].Height != 11 {
+		t.Errorf("tasks after the restart %+v", node.blockchain.tasks)
+	}
+}
@@ -0,0 +1,163 @@
+package btc
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/SwingbyProtocol/tx-indexer/resolver"
+)
+
+// EsploraSource loads chain data from the HTTP API of Esplora/Electrs (e.g.
+// https://blockstream.info/api). The API does not report the chain, so the
+// params have to be given.
+type EsploraSource struct {
+	// the last tip height seen, used to count the confirmations of blocks
+	tip      int64
+	resolver *resolver.Resolver
+	params   *Params
+}
+
+// esploraBlock is a block as returned by /block/:hash
+type esploraBlock struct {
+	ID                string `json:"id"`
+	Height            int64  `json:"height"`
+	Timestamp         int64  `json:"timestamp"`
+	Mediantime        int64  `json:"mediantime"`
+	TxCount           int64  `json:"tx_count"`
+	Previousblockhash string `json:"previousblockhash"`
+}
+
+func NewEsploraSource(uris []string, params *Params) *EsploraSource {
+	for i, uri := range uris {
+		uris[i] = strings.TrimSuffix(uri, "/")
+	}
+	return &EsploraSource{
+		resolver: resolver.NewMultiResolver(uris),
+		params:   params,
+	}
+}
+
+// Resolver is used to record or replay the responses
+func (s *EsploraSource) Resolver() *resolver.Resolver {
+	return s.resolver
+}
+
+func (s *EsploraSource) StartHealthCheck(t time.Duration) {
+	s.resolver.StartHealthCheck(t, func(e *resolver.Endpoint) (int64, error) {
+		data, err := s.resolver.GetRawRequestFrom(e, "/blocks/tip/height")
+		if err != nil {
+			return 0, err
+		}
+		return parseHeight(data)
+	})
+}
+
+func (s *EsploraSource) getText(query string) (string, error) {
+	data, err := s.resolver.GetRawRequest(query)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *EsploraSource) getTipHeight() (int64, error) {
+	data, err := s.resolver.GetRawRequest("/blocks/tip/height")
+	if err != nil {
+		return 0, err
+	}
+	height, err := parseHeight(data)
+	if err != nil {
+		return 0, err
+	}
+	atomic.StoreInt64(&s.tip, height)
+	return height, nil
+}
+
+func (s *EsploraSource) GetChainInfo() (*ChainInfo, error) {
+	height, err := s.getTipHeight()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := s.GetBlockHash(height)
+	if err != nil {
+		return nil, err
+	}
+	return &ChainInfo{
+		Chain:         s.params.Name,
+		Blocks:        height,
+		Headers:       height,
+		Bestblockhash: hash,
+	}, nil
+}
+
+func (s *EsploraSource) GetBlockHash(height int64) (string, error) {
+	return s.getText("/block-height/" + strconv.FormatInt(height, 10))
+}
+
+func (s *EsploraSource) GetBlock(hash string) (*Block, error) {
+	info := esploraBlock{}
+	err := s.resolver.GetRequest("/block/"+hash, &info)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.resolver.GetRawRequest("/block/" + hash + "/raw")
+	if err != nil {
+		return nil, err
+	}
+	block, err := DecodeBlock(data, s.params)
+	if err != nil {
+		return nil, err
+	}
+	if block.Hash != hash {
+		return nil, errors.New("Block hash mismatch " + hash + " -> " + block.Hash)
+	}
+	block.Height = info.Height
+	block.Mediantime = info.Mediantime
+	// the sync loads blocks up to the tip of the last GetChainInfo
+	block.Confirmations = 1
+	tip := atomic.LoadInt64(&s.tip)
+	if tip > info.Height {
+		block.Confirmations = tip - info.Height + 1
+	}
+	return block, nil
+}
+
+func (s *EsploraSource) GetTx(txid string) (*Tx, error) {
+	text, err := s.getText("/tx/" + txid + "/hex")
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeTx(data, s.params)
+}
+
+// GetMempool returns all mempool txids. Esplora does not report when a tx
+// entered the mempool, so the time is when it was first seen here.
+func (s *EsploraSource) GetMempool() (map[string]PoolTx, error) {
+	height, err := s.getTipHeight()
+	if err != nil {
+		return nil, err
+	}
+	txids := []string{}
+	err = s.resolver.GetRequest("/mempool/txids", &txids)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	res := make(map[string]PoolTx)
+	for _, txid := range txids {
+		res[txid] = PoolTx{Height: height, Time: now}
+	}
+	return res, nil
+}
+
+func parseHeight(data []byte) (int64, error) {
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
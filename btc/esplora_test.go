@@ -0,0 +1,165 @@
+package btc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SwingbyProtocol/tx-indexer/resolver"
+)
+
+const genesisHash = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+
+// fakeEsplora serves the genesis block at the given tip height and the
+// segwit fixture tx in the mempool, and counts the requests per path
+type fakeEsplora struct {
+	*httptest.Server
+	tip      int64
+	spend    []byte
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newFakeEsplora(t *testing.T, tip int64) *fakeEsplora {
+	_, spend := loadTxFixture(t, "getrawtransaction_spend_v23.json")
+	f := &fakeEsplora{tip: tip, spend: spend, requests: make(map[string]int)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+func (f *fakeEsplora) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[path]
+}
+
+func (f *fakeEsplora) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests[r.URL.Path]++
+	f.mu.Unlock()
+	switch r.URL.Path {
+	case "/blocks/tip/height":
+		w.Write([]byte(strconv.FormatInt(f.tip, 10)))
+	case "/block-height/" + strconv.FormatInt(f.tip, 10), "/block-height/0":
+		w.Write([]byte(genesisHash))
+	case "/block/" + genesisHash:
+		json.NewEncoder(w).Encode(esploraBlock{
+			ID:        genesisHash,
+			Height:    0,
+			Timestamp: 1231006505,
+			// the genesis block has no previous blocks, esplora reports its time
+			Mediantime: 1231006505,
+			TxCount:    1,
+		})
+	case "/block/" + genesisHash + "/raw":
+		data, _ := hex.DecodeString(genesisHeader + "01" + genesisTx)
+		w.Write(data)
+	case "/tx/" + spendTxid + "/hex":
+		w.Write([]byte(hex.EncodeToString(f.spend) + "\n"))
+	case "/mempool/txids":
+		json.NewEncoder(w).Encode([]string{spendTxid})
+	default:
+		http.Error(w, "Block not found", http.StatusNotFound)
+	}
+}
+
+func TestEsploraChainInfo(t *testing.T) {
+	f := newFakeEsplora(t, 0)
+	defer f.Close()
+	s := NewEsploraSource([]string{f.URL + "/"}, MainNetParams)
+	info, err := s.GetChainInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Chain != "main" || info.Blocks != 0 || info.Bestblockhash != genesisHash {
+		t.Errorf("chain info %+v", info)
+	}
+}
+
+func TestEsploraGetBlock(t *testing.T) {
+	f := newFakeEsplora(t, 9)
+	defer f.Close()
+	s := NewEsploraSource([]string{f.URL}, MainNetParams)
+	_, err := s.GetChainInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		block, err := s.GetBlock(genesisHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Hash != genesisHash || block.Height != 0 || block.Mediantime != 1231006505 || block.Ntx != 1 {
+			t.Errorf("block %+v", block)
+		}
+		if block.Confirmations != 10 {
+			t.Errorf("confirmations %d, want 10", block.Confirmations)
+		}
+	}
+	// blocks count their confirmations from the tip of GetChainInfo
+	if f.count("/blocks/tip/height") != 1 {
+		t.Errorf("tip height is requested %d times", f.count("/blocks/tip/height"))
+	}
+	_, err = s.GetBlock(strings.Repeat("0", 64))
+	if errors.Is(err, resolver.ErrNotFound) == false {
+		t.Errorf("unknown block error %v", err)
+	}
+}
+
+func TestEsploraGetTx(t *testing.T) {
+	f := newFakeEsplora(t, 0)
+	defer f.Close()
+	s := NewEsploraSource([]string{f.URL}, MainNetParams)
+	tx, err := s.GetTx(spendTxid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Txid != spendTxid || tx.Hash != spendHash || len(tx.Vout) != 4 {
+		t.Errorf("tx %s %s with %d vouts", tx.Txid, tx.Hash, len(tx.Vout))
+	}
+	_, err = s.GetTx(strings.Repeat("0", 64))
+	if errors.Is(err, resolver.ErrNotFound) == false {
+		t.Errorf("unknown tx error %v", err)
+	}
+}
+
+func TestEsploraGetMempool(t *testing.T) {
+	f := newFakeEsplora(t, 5)
+	defer f.Close()
+	s := NewEsploraSource([]string{f.URL}, MainNetParams)
+	pool, err := s.GetMempool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := pool[spendTxid]
+	if len(pool) != 1 || ok == false || entry.Height != 5 || entry.Time == 0 {
+		t.Errorf("mempool %+v", pool)
+	}
+	// esplora lists no fees with the txids
+	if entry.Fee != nil {
+		t.Errorf("fee %d", *entry.Fee)
+	}
+}
+
+func TestEsploraFailover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	f := newFakeEsplora(t, 0)
+	defer f.Close()
+	s := NewEsploraSource([]string{down.URL, f.URL}, MainNetParams)
+	tx, err := s.GetTx(spendTxid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Txid != spendTxid {
+		t.Errorf("txid %s", tx.Txid)
+	}
+}
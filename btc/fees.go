@@ -0,0 +1,108 @@
+package btc
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+const (
+	blockVsize = 1000000
+	// minimum relay fee rate of bitcoind
+	minFeerate = 1
+	feesTTL    = 10 * time.Second
+)
+
+// lower bounds of the histogram buckets in sat/vB
+var feeBuckets = []float64{
+	0, 1, 2, 3, 4, 5, 6, 8, 10, 12, 15, 20, 30, 40, 50, 60, 70, 80, 90, 100,
+	125, 150, 175, 200, 250, 300, 350, 400, 500, 600, 700, 800, 900, 1000,
+	1200, 1400, 1700, 2000,
+}
+
+type FeeBucket struct {
+	Feerate float64 `json:"feerate"`
+	Count   int     `json:"count"`
+	Vsize   int     `json:"vsize"`
+}
+
+// FeeEstimate holds the recommended fee rates in sat/vB for the next block,
+// three blocks (~30 min) and six blocks (~1 h)
+type FeeEstimate struct {
+	Nextblock float64      `json:"nextblock"`
+	Halfhour  float64      `json:"halfhour"`
+	Hour      float64      `json:"hour"`
+	Count     int          `json:"count"`
+	Vsize     int          `json:"vsize"`
+	Histogram []*FeeBucket `json:"histogram"`
+	Time      int64        `json:"time"`
+}
+
+type feeTx struct {
+	vsize   int
+	feerate float64
+}
+
+// EstimateFees fills blocks with the mempool txs paying the most first.
+// Entries whose fee is unknown or negative are skipped.
+func EstimateFees(txs []PoolTx) *FeeEstimate {
+	list := []feeTx{}
+	for _, tx := range txs {
+		if tx.Fee != nil && *tx.Fee >= 0 && tx.Vsize > 0 {
+			list = append(list, feeTx{tx.Vsize, float64(*tx.Fee) / float64(tx.Vsize)})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].feerate > list[j].feerate
+	})
+	est := &FeeEstimate{
+		Nextblock: minFeerate,
+		Halfhour:  minFeerate,
+		Hour:      minFeerate,
+		Count:     len(list),
+		Time:      time.Now().Unix(),
+	}
+	for _, feerate := range feeBuckets {
+		est.Histogram = append(est.Histogram, &FeeBucket{Feerate: feerate})
+	}
+	for _, tx := range list {
+		before := est.Vsize
+		est.Vsize += tx.vsize
+		rate := math.Max(math.Ceil(tx.feerate), minFeerate)
+		if before < blockVsize && est.Vsize >= blockVsize {
+			est.Nextblock = rate
+		}
+		if before < 3*blockVsize && est.Vsize >= 3*blockVsize {
+			est.Halfhour = rate
+		}
+		if before < 6*blockVsize && est.Vsize >= 6*blockVsize {
+			est.Hour = rate
+		}
+		i := sort.Search(len(feeBuckets), func(i int) bool {
+			return feeBuckets[i] > tx.feerate
+		}) - 1
+		est.Histogram[i].Count++
+		est.Histogram[i].Vsize += tx.vsize
+	}
+	return est
+}
+
+func (node *Node) getFees() *FeeEstimate {
+	node.feemu.Lock()
+	defer node.feemu.Unlock()
+	if node.fees != nil && time.Since(time.Unix(node.fees.Time, 0)) < feesTTL {
+		return node.fees
+	}
+	// sources without fees in the mempool listing have the fees of the
+	// indexed txs in their entries
+	node.fees = EstimateFees(node.blockchain.mempool.Entries())
+	return node.fees
+}
+
+func (node *Node) GetFees(w rest.ResponseWriter, r *rest.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.WriteJson(node.getFees())
+}
@@ -0,0 +1,128 @@
+package btc
+
+import (
+	"testing"
+)
+
+// poolTxs returns count entries of vsize paying feerate sat/vB
+func poolTxs(count int, vsize int, feerate float64) []PoolTx {
+	txs := []PoolTx{}
+	for i := 0; i < count; i++ {
+		fee := int64(feerate * float64(vsize))
+		txs = append(txs, PoolTx{Vsize: vsize, Fee: &fee})
+	}
+	return txs
+}
+
+func joinPoolTxs(lists ...[]PoolTx) []PoolTx {
+	txs := []PoolTx{}
+	for _, list := range lists {
+		txs = append(txs, list...)
+	}
+	return txs
+}
+
+func TestEstimateFeesThresholds(t *testing.T) {
+	tests := []struct {
+		name      string
+		txs       []PoolTx
+		nextblock float64
+		halfhour  float64
+		hour      float64
+		count     int
+	}{
+		{"empty", nil, minFeerate, minFeerate, minFeerate, 0},
+		{"less than a block", poolTxs(5, 100000, 50), minFeerate, minFeerate, minFeerate, 5},
+		{
+			// blocks of 10 txs of 100 kvB each are filled at the 10th, the
+			// 30th and the 60th tx
+			"six blocks",
+			joinPoolTxs(poolTxs(10, 100000, 50), poolTxs(20, 100000, 20), poolTxs(30, 100000, 5), poolTxs(10, 100000, 2)),
+			50, 20, 5, 70,
+		},
+		{
+			// the order of the entries does not matter
+			"unsorted",
+			joinPoolTxs(poolTxs(10, 100000, 2), poolTxs(30, 100000, 5), poolTxs(20, 100000, 20), poolTxs(10, 100000, 50)),
+			50, 20, 5, 70,
+		},
+		{
+			// fee rates are rounded up to whole sat/vB
+			"rounded up",
+			poolTxs(60, 100000, 10.2),
+			11, 11, 11, 60,
+		},
+		{
+			// below the minimum relay fee rate
+			"minimum",
+			poolTxs(60, 100000, 0.5),
+			minFeerate, minFeerate, minFeerate, 60,
+		},
+	}
+	for _, test := range tests {
+		est := EstimateFees(test.txs)
+		if est.Nextblock != test.nextblock || est.Halfhour != test.halfhour || est.Hour != test.hour {
+			t.Errorf("%s: estimate %v %v %v, want %v %v %v", test.name, est.Nextblock, est.Halfhour, est.Hour, test.nextblock, test.halfhour, test.hour)
+		}
+		if est.Count != test.count {
+			t.Errorf("%s: count %d, want %d", test.name, est.Count, test.count)
+		}
+	}
+}
+
+func TestEstimateFeesHistogram(t *testing.T) {
+	negative := int64(-1000)
+	txs := joinPoolTxs(
+		poolTxs(1, 100, 0.5),
+		poolTxs(2, 100, 1),
+		poolTxs(1, 200, 7.9),
+		poolTxs(1, 100, 8),
+		poolTxs(1, 100, 5000),
+		// unknown, negative and sizeless entries are skipped
+		[]PoolTx{{Vsize: 100}, {Vsize: 100, Fee: &negative}, {Fee: &negative}},
+	)
+	est := EstimateFees(txs)
+	if est.Count != 6 || est.Vsize != 700 {
+		t.Errorf("count %d vsize %d, want 6 and 700", est.Count, est.Vsize)
+	}
+	want := map[float64]FeeBucket{
+		0:    {0, 1, 100},
+		1:    {1, 2, 200},
+		6:    {6, 1, 200},
+		8:    {8, 1, 100},
+		2000: {2000, 1, 100},
+	}
+	if len(est.Histogram) != len(feeBuckets) {
+		t.Fatalf("%d buckets, want %d", len(est.Histogram), len(feeBuckets))
+	}
+	for _, bucket := range est.Histogram {
+		w := want[bucket.Feerate]
+		w.Feerate = bucket.Feerate
+		if *bucket != w {
+			t.Errorf("bucket %+v, want %+v", *bucket, w)
+		}
+	}
+}
+
+func TestMempoolSetFee(t *testing.T) {
+	source := &fakeSource{}
+	mem := NewMempool(source, 1)
+	mem.pool[spendTxid] = true
+	mem.entries[spendTxid] = PoolTx{Time: 10}
+	mem.SetFee(spendTxid, 250, 5000)
+	mem.SetFee(coinbaseTxid, 250, 5000)
+	entries := mem.Entries()
+	if len(entries) != 1 || entries[0].Fee == nil || *entries[0].Fee != 5000 || entries[0].Vsize != 250 || entries[0].Time != 10 {
+		t.Fatalf("entries %+v", entries)
+	}
+	// the fee is kept when the source lists the tx again without one
+	source.pool = map[string]PoolTx{spendTxid: {Time: 10}}
+	err := mem.loadTxsIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries = mem.Entries()
+	if len(entries) != 1 || entries[0].Fee == nil || *entries[0].Fee != 5000 {
+		t.Errorf("entries after the reload %+v", entries)
+	}
+}
@@ -8,10 +8,14 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// Index maps script hashes (see Scripthash) to txs. Lookups take an address
+// or a script hash, and addresses are resolved to the script they pay.
 type Index struct {
 	lists   []*Score
 	counter map[string]int
 	stamps  map[string][]*Stamp
+	// txs spending from a script, by the scripts of their prevouts
+	sends map[string][]*Stamp
 }
 
 type Stamp struct {
@@ -21,58 +25,80 @@ type Stamp struct {
 }
 
 type Score struct {
+	// the script hash
 	Address string
 	Time    int64
 	Txid    string
 }
 
 type Link struct {
-	Txs     []string
-	Address string
+	Txs        []string
+	Scripthash string
+	// the P2PKH alias of a P2PK output, see ScriptPubkey.Scripthashes
+	Alias string
+}
+
+func (l *Link) matches(scripthash string) bool {
+	return l.Scripthash == scripthash || (l.Alias != "" && l.Alias == scripthash)
 }
 
 func NewIndex() *Index {
 	index := &Index{
 		counter: make(map[string]int),
 		stamps:  make(map[string][]*Stamp),
+		sends:   make(map[string][]*Stamp),
 	}
 	return index
 }
 
 func (i *Index) AddIn(tx *Tx) {
 	stamp := &Stamp{tx.Txid, tx.Receivedtime, nil}
+	// one link per output, so the links line up with the vouts
 	for _, vout := range tx.Vout {
-		if len(vout.Scriptpubkey.Addresses) != 1 {
-			continue
+		link := &Link{}
+		scripthashes := vout.Scriptpubkey.Scripthashes()
+		if len(scripthashes) > 0 {
+			link.Scripthash = scripthashes[0]
 		}
-		addr := vout.Scriptpubkey.Addresses[0]
-		stamp.Vout = append(stamp.Vout, &Link{Address: addr})
+		if len(scripthashes) > 1 {
+			link.Alias = scripthashes[1]
+		}
+		stamp.Vout = append(stamp.Vout, link)
 	}
-	addresses := tx.GetOutputsAddresses()
+	scripthashes := tx.GetOutputsScripthashes()
 	lock := GetMu()
-	for _, addr := range addresses {
+	for _, scripthash := range scripthashes {
 		lock.Lock()
-		i.stamps[addr] = append(i.stamps[addr], stamp)
+		i.stamps[scripthash] = append(i.stamps[scripthash], stamp)
 		// Insertion Sort
-		sortStamp(i.stamps[addr])
-		i.UpdateScore(addr, tx.Receivedtime, tx.Txid)
+		sortStamp(i.stamps[scripthash])
+		i.UpdateScore(scripthash, tx.Receivedtime, tx.Txid)
 		// Insertion Sort
 		sortScores(i.lists)
 		lock.Unlock()
 	}
+	for _, scripthash := range tx.GetInputsScripthashes() {
+		lock.Lock()
+		i.sends[scripthash] = append(i.sends[scripthash], stamp)
+		sortStamp(i.sends[scripthash])
+		i.UpdateScore(scripthash, tx.Receivedtime, tx.Txid)
+		sortScores(i.lists)
+		lock.Unlock()
+	}
 }
 
-func (i *Index) AddVouts(addr string, storage *Storage) error {
-	index := i.GetStamps(addr)
-	if index == nil {
+func (i *Index) AddVouts(addr string, storage Storage) error {
+	scripthash := ToScripthash(addr)
+	index := i.GetStamps(scripthash)
+	if index == nil && i.getSends(scripthash) == nil {
 		return errors.New("index is not exist")
 	}
-	for _, in := range i.stamps[addr] {
+	for _, in := range index {
 		for i, out := range in.Vout {
 			if len(out.Txs) != 0 {
 				continue
 			}
-			if addr != out.Address {
+			if out.matches(scripthash) == false {
 				continue
 			}
 			key := in.Txid + "_" + strconv.Itoa(i)
@@ -86,7 +112,7 @@ func (i *Index) AddVouts(addr string, storage *Storage) error {
 	return nil
 }
 
-func (i *Index) RemoveIndexWithTxBefore(blockchian *BlockChain, storage *Storage) error {
+func (i *Index) RemoveIndexWithTxBefore(blockchian *BlockChain, storage Storage) error {
 	time, err := blockchian.GetPruneBlockTime()
 	if err != nil {
 		return err
@@ -95,24 +121,46 @@ func (i *Index) RemoveIndexWithTxBefore(blockchian *BlockChain, storage *Storage
 	return nil
 }
 
-func (i *Index) GetSpents(addr string, storage *Storage) ([]*Tx, error) {
+// GetSpents returns the txs spending from addr. They are indexed by their
+// prevouts, and the spent links of the outputs to addr fill in the txs whose
+// prevouts were not known when they were indexed.
+func (i *Index) GetSpents(addr string, storage Storage) ([]*Tx, error) {
 	res := []*Tx{}
-	ins := i.GetStamps(addr)
-	if ins == nil {
+	scripthash := ToScripthash(addr)
+	ins := i.GetStamps(scripthash)
+	sends := i.getSends(scripthash)
+	if ins == nil && sends == nil {
 		return nil, errors.New("index is not exist")
 	}
+	txids := []string{}
+	for _, send := range sends {
+		txids = append(txids, send.Txid)
+	}
 	for _, in := range ins {
 		for _, link := range in.Vout {
-			for _, txID := range link.Txs {
-				tx := storage.txs[txID]
-				res = append(res, tx)
+			if link.matches(scripthash) == false {
+				continue
 			}
+			txids = append(txids, link.Txs...)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, txid := range txids {
+		if seen[txid] == true {
+			continue
+		}
+		seen[txid] = true
+		tx, err := storage.GetTx(txid)
+		if err != nil {
+			continue
 		}
+		res = append(res, tx)
 	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Receivedtime < res[j].Receivedtime })
 	return res, nil
 }
 
-func (i *Index) GetIns(addr string, storage *Storage) ([]*Tx, error) {
+func (i *Index) GetIns(addr string, storage Storage) ([]*Tx, error) {
 	res := []*Tx{}
 	ins := i.GetStamps(addr)
 	if ins == nil {
@@ -128,21 +176,45 @@ func (i *Index) GetIns(addr string, storage *Storage) ([]*Tx, error) {
 	return res, nil
 }
 
+// HasTx reports whether tx is indexed by the scripts it pays
+func (i *Index) HasTx(tx *Tx) bool {
+	for _, scripthash := range tx.GetOutputsScripthashes() {
+		for _, stamp := range i.GetStamps(scripthash) {
+			if stamp.Txid == tx.Txid {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetStamps returns the txs paying to addr, which is an address or a script
+// hash
 func (i *Index) GetStamps(addr string) []*Stamp {
+	scripthash := ToScripthash(addr)
 	lock := GetMu()
 	lock.RLock()
-	stamps := i.stamps[addr]
+	stamps := i.stamps[scripthash]
 	lock.RUnlock()
 	return stamps
 }
 
+func (i *Index) getSends(addr string) []*Stamp {
+	scripthash := ToScripthash(addr)
+	lock := GetMu()
+	lock.RLock()
+	sends := i.sends[scripthash]
+	lock.RUnlock()
+	return sends
+}
+
 func (i *Index) UpdateScore(addr string, time int64, txid string) {
 	newScore := &Score{Address: addr, Time: time, Txid: txid}
 	i.lists = append(i.lists, newScore)
 	i.counter[addr]++
 }
 
-func (i *Index) removeIndexWIthAllSpentTxBefore(prunetime int64, storage *Storage) {
+func (i *Index) removeIndexWIthAllSpentTxBefore(prunetime int64, storage Storage) {
 	indexTotal := 0
 	txTotal := 0
 	spentTotal := 0
@@ -194,6 +266,7 @@ func (i *Index) removeIndex(addr string) {
 	lock := GetMu()
 	lock.Lock()
 	delete(i.stamps, addr)
+	delete(i.sends, addr)
 	lock.Unlock()
 }
 
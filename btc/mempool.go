@@ -1,6 +1,7 @@
 package btc
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -8,29 +9,111 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// PoolTx is an entry of the verbose mempool of bitcoind. Fee is in
+// satoshis and nil when the source does not report it.
 type PoolTx struct {
-	Height int64 `json:"height"`
-	Time   int64 `json:"time"`
+	Height int64  `json:"height"`
+	Time   int64  `json:"time"`
+	Vsize  int    `json:"vsize"`
+	Fee    *int64 `json:"-"`
 }
 
+type poolTxFees struct {
+	Base json.RawMessage `json:"base"`
+}
+
+type poolTxJSON struct {
+	Height int64           `json:"height"`
+	Time   int64           `json:"time"`
+	Vsize  int             `json:"vsize"`
+	Fees   *poolTxFees     `json:"fees,omitempty"`
+	Fee    json.RawMessage `json:"fee,omitempty"`
+}
+
+// MarshalJSON writes the fee in BTC as fees.base like bitcoind
+func (ptx PoolTx) MarshalJSON() ([]byte, error) {
+	res := poolTxJSON{Height: ptx.Height, Time: ptx.Time, Vsize: ptx.Vsize}
+	if ptx.Fee != nil {
+		res.Fees = &poolTxFees{Base: json.RawMessage(FormatBTC(*ptx.Fee))}
+	}
+	return json.Marshal(res)
+}
+
+// UnmarshalJSON reads the fee from fees.base, or from fee which bitcoind
+// before 23.0 reports
+func (ptx *PoolTx) UnmarshalJSON(data []byte) error {
+	res := poolTxJSON{}
+	err := json.Unmarshal(data, &res)
+	if err != nil {
+		return err
+	}
+	fee := res.Fee
+	if res.Fees != nil && len(res.Fees.Base) > 0 {
+		fee = res.Fees.Base
+	}
+	sat, err := readAmount(fee, nil)
+	if err != nil {
+		return err
+	}
+	*ptx = PoolTx{Height: res.Height, Time: res.Time, Vsize: res.Vsize, Fee: sat}
+	return nil
+}
+
+const (
+	memMaxRetries = 8
+	memRetryDelay = 1 * time.Second
+)
+
 type Mempool struct {
-	pool     map[string]bool
-	tasks    []*Tx
-	resolver *resolver.Resolver
+	pool map[string]bool
+	// the last entries reported by the source
+	entries  map[string]PoolTx
+	tasks    []*memTask
+	source   Source
 	waitchan chan Tx
-	iswork   bool
+	// txs which have left the mempool, mined or not
+	removechan chan string
+	workers    int
+	running    int
+	retrying   int
+	fetched    int64
+	failed     int64
+}
+
+// memTask is a tx waiting to be fetched
+type memTask struct {
+	tx      *Tx
+	retries int
 }
 
-func NewMempool(uri string) *Mempool {
+// MempoolStats reports the tx fetch queue
+type MempoolStats struct {
+	Queued   int
+	Running  int
+	Retrying int
+	Fetched  int64
+	Failed   int64
+}
+
+func NewMempool(source Source, workers int) *Mempool {
+	if workers < 1 {
+		workers = 1
+	}
 	mem := &Mempool{
-		pool:     make(map[string]bool),
-		resolver: resolver.NewResolver(uri),
-		waitchan: make(chan Tx),
+		pool:       make(map[string]bool),
+		entries:    make(map[string]PoolTx),
+		source:     source,
+		waitchan:   make(chan Tx),
+		removechan: make(chan string),
+		workers:    workers,
 	}
 	return mem
 }
 
 func (mem *Mempool) StartSync(t time.Duration) {
+	for i := 0; i < mem.workers; i++ {
+		go mem.doGetTx()
+	}
 	go mem.doGetTxIDs(t)
 }
 
@@ -44,98 +127,198 @@ func (mem *Mempool) doGetTxIDs(t time.Duration) {
 	return
 }
 
+// loadTxsIDs diffs the mempool of the source against the known pool. New
+// txs are queued to be fetched and txs which have left are forgotten.
 func (mem *Mempool) loadTxsIDs() error {
-	res := make(map[string]PoolTx)
-	err := mem.resolver.GetRequest("/rest/mempool/contents.json", &res)
+	res, err := mem.source.GetMempool()
 	if err != nil {
 		return err
 	}
-	height := int64(0)
-	for _, tx := range res {
-		if tx.Height > height {
-			height = tx.Height
+	lock := GetMu()
+	lock.Lock()
+	for id, tx := range res {
+		// keep the fees set from the indexed txs
+		old, ok := mem.entries[id]
+		if tx.Fee == nil && ok == true && old.Fee != nil {
+			tx.Vsize = old.Vsize
+			tx.Fee = old.Fee
+			res[id] = tx
 		}
 	}
-	txs := []string{}
+	mem.entries = res
+	added := 0
 	for id, tx := range res {
-		if tx.Height != height {
+		if mem.pool[id] == true {
 			continue
 		}
+		mem.pool[id] = true
 		newTx := Tx{
 			Txid:         id,
 			Receivedtime: tx.Time,
 		}
-		txs = append(txs, id)
-		if mem.pool[id] == true {
-			continue
+		mem.tasks = append(mem.tasks, &memTask{tx: &newTx})
+		added++
+	}
+	removed := []string{}
+	for id := range mem.pool {
+		_, ok := res[id]
+		if ok == false {
+			delete(mem.pool, id)
+			removed = append(removed, id)
 		}
-		mem.pool[id] = true
-		mem.tasks = append(mem.tasks, &newTx)
 	}
-	log.Infof(" --- task_count -> %d --- ", len(mem.tasks))
-	if mem.iswork == false {
-		mem.iswork = true
-		go mem.doGetTx()
+	if len(removed) > 0 {
+		tasks := []*memTask{}
+		for _, task := range mem.tasks {
+			if mem.pool[task.tx.Txid] == true {
+				tasks = append(tasks, task)
+			}
+		}
+		mem.tasks = tasks
+	}
+	lock.Unlock()
+	for _, id := range removed {
+		mem.removechan <- id
 	}
-	mem.removePool(txs)
+	log.Infof(" --- mempool -> %d added -> %d removed -> %d --- ", len(res), added, len(removed))
 	return nil
 }
 
+// doGetTx is a worker of the fixed size pool which fetches queued txs
 func (mem *Mempool) doGetTx() {
-	err := mem.getTx()
-	if err != nil {
-		mem.iswork = false
-		return
+	for {
+		task := mem.nextTask()
+		if task == nil {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		mem.getTx(task)
 	}
-	time.Sleep(1 * time.Microsecond)
-	go mem.doGetTx()
-	return
 }
 
-func (mem *Mempool) removePool(txs []string) {
-	if len(mem.pool) <= 1000 {
+func (mem *Mempool) nextTask() *memTask {
+	lock := GetMu()
+	lock.Lock()
+	defer lock.Unlock()
+	if len(mem.tasks) == 0 {
+		return nil
+	}
+	task := mem.tasks[0]
+	mem.tasks = mem.tasks[1:]
+	mem.running++
+	return task
+}
+
+func (mem *Mempool) getTx(task *memTask) {
+	err := task.tx.AddTxData(mem.source)
+	lock := GetMu()
+	lock.Lock()
+	mem.running--
+	if err == nil {
+		mem.fetched++
+		lock.Unlock()
+		mem.waitchan <- *task.tx
 		return
 	}
-	for tx := range mem.pool {
-		isMatch := false
-		for _, txID := range txs {
-			if tx == txID {
-				isMatch = true
-			}
-		}
-		if isMatch == false {
-			delete(mem.pool, tx)
-		}
+	// the tx has left the mempool
+	if errors.Is(err, resolver.ErrNotFound) || task.retries >= memMaxRetries {
+		mem.failed++
+		lock.Unlock()
+		log.Debugf("Tx %s dropped after %d retries: %s", task.tx.Txid, task.retries, err)
+		return
 	}
+	task.retries++
+	mem.retrying++
+	lock.Unlock()
+	// back off exponentially so a busy bitcoind can catch up
+	time.AfterFunc(memRetryDelay<<uint(task.retries-1), func() {
+		lock.Lock()
+		mem.retrying--
+		mem.tasks = append(mem.tasks, task)
+		lock.Unlock()
+	})
 }
 
-func (mem *Mempool) getTx() error {
+// AddRawTx indexes a tx pushed by a notification without fetching it again
+func (mem *Mempool) AddRawTx(data []byte, params *Params) error {
+	tx, err := DecodeTx(data, params)
+	if err != nil {
+		return err
+	}
 	lock := GetMu()
 	lock.Lock()
-	if len(mem.tasks) == 0 {
+	if mem.pool[tx.Txid] == true {
 		lock.Unlock()
-		return errors.New("task zero")
+		return nil
 	}
-	tx := mem.tasks[0]
-	mem.tasks = mem.tasks[1:]
-	resolver := mem.resolver
+	mem.pool[tx.Txid] = true
 	lock.Unlock()
-	go func() {
-		err := tx.AddTxData(resolver)
-		if err != nil {
-			if err.Error() == "404" {
-				return
-			}
-			lock.Lock()
-			mem.tasks = append(mem.tasks, tx)
-			lock.Unlock()
-			return
-		}
-		mem.waitchan <- *tx
-	}()
+	tx.Receivedtime = time.Now().Unix()
+	mem.waitchan <- *tx
 	return nil
 }
 
+func (mem *Mempool) RemoveTx(txid string) {
+	lock := GetMu()
+	lock.Lock()
+	_, ok := mem.pool[txid]
+	delete(mem.pool, txid)
+	lock.Unlock()
+	if ok == true {
+		mem.removechan <- txid
+	}
+}
+
+// SetFee sets the fee of a pool entry which the source reported without
+// one, from the tx once it is indexed
+func (mem *Mempool) SetFee(txid string, vsize int, fee int64) {
+	lock := GetMu()
+	lock.Lock()
+	defer lock.Unlock()
+	if mem.pool[txid] == false {
+		return
+	}
+	entry := mem.entries[txid]
+	if entry.Fee != nil {
+		return
+	}
+	entry.Vsize = vsize
+	entry.Fee = &fee
+	mem.entries[txid] = entry
+}
+
+// Entries returns the entries of the txs in the pool
+func (mem *Mempool) Entries() []PoolTx {
+	lock := GetMu()
+	lock.RLock()
+	defer lock.RUnlock()
+	res := make([]PoolTx, 0, len(mem.pool))
+	for txid := range mem.pool {
+		res = append(res, mem.entries[txid])
+	}
+	return res
+}
+
+func (mem *Mempool) HasTx(txid string) bool {
+	lock := GetMu()
+	lock.RLock()
+	defer lock.RUnlock()
+	return mem.pool[txid]
+}
+
 func (mem *Mempool) GetTaskCount() int {
 	return len(mem.tasks)
 }
+
+func (mem *Mempool) Stats() MempoolStats {
+	lock := GetMu()
+	lock.RLock()
+	defer lock.RUnlock()
+	return MempoolStats{
+		Queued:   len(mem.tasks),
+		Running:  mem.running,
+		Retrying: mem.retrying,
+		Fetched:  mem.fetched,
+		Failed:   mem.failed,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/SwingbyProtocol/tx-indexer/pubsub"
@@ -14,41 +15,191 @@ import (
 )
 
 const (
-	WATCHTXS   = "watchTxs"
-	UNWATCHTXS = "unwatchTxs"
-	GETTXS     = "getTxs"
+	WATCHTXS    = "watchTxs"
+	UNWATCHTXS  = "unwatchTxs"
+	GETTXS      = "getTxs"
+	GETBALANCE  = "getBalance"
+	GETUTXOS    = "getUtxos"
+	TXDROPPED   = "txDropped"
+	DOUBLESPEND = "doubleSpend"
 )
 
 type Node struct {
 	blockchain *BlockChain
 	index      *Index
-	storage    *Storage
+	storage    Storage
 	upgrader   *websocket.Upgrader
 	ps         *pubsub.PubSub
+	datadir    string
+	zmqaddr    string
+	// txs which have left the mempool by the height of the last block
+	removed    map[string]int64
+	lastheight int64
+	prevlookup bool
+	fees       *FeeEstimate
+	feemu      sync.Mutex
+	// height and time of the last saved checkpoint
+	savedheight int64
+	savedtime   time.Time
+	// serializes the tx and block subscribers, which both read, change and
+	// write back stored txs
+	txmu sync.Mutex
+	// poll intervals from the config
+	syncinterval time.Duration
+	meminterval  time.Duration
 }
 
-func NewNode(uri string, purneblocks int) *Node {
+type Config struct {
+	Source          Source
+	PruneBlocks     int
+	DataDir         string
+	Storage         Storage
+	StartHeight     int64
+	BackfillWorkers int
+	MempoolWorkers  int
+	ZMQAddr         string
+	PrevoutLookup   bool
+	// poll intervals of the block and mempool sync, zero for the defaults
+	SyncInterval    time.Duration
+	MempoolInterval time.Duration
+}
+
+func NewNode(conf *Config) *Node {
 	upgrader := websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 	}
 	node := &Node{
-		blockchain: NewBlockchain(uri, purneblocks),
-		index:      NewIndex(),
-		storage:    NewStorage(),
-		ps:         &pubsub.PubSub{},
-		upgrader:   &upgrader,
+		blockchain:   NewBlockchain(conf),
+		index:        NewIndex(),
+		storage:      conf.Storage,
+		ps:           &pubsub.PubSub{},
+		upgrader:     &upgrader,
+		datadir:      conf.DataDir,
+		zmqaddr:      conf.ZMQAddr,
+		removed:      make(map[string]int64),
+		prevlookup:   conf.PrevoutLookup,
+		syncinterval: conf.SyncInterval,
+		meminterval:  conf.MempoolInterval,
 	}
+	node.loadCheckpoint()
+	node.reindexStorage()
 	return node
 }
 
+// reindexStorage indexes the stored txs which are missing from the index. An
+// on-disk storage keeps the txs added after the last checkpoint, and as they
+// are already stored addTx would not index them again.
+func (node *Node) reindexStorage() {
+	count := 0
+	err := node.storage.ForEachTx(func(tx *Tx) error {
+		if node.index.HasTx(tx) == true {
+			return nil
+		}
+		node.index.AddIn(tx)
+		count++
+		return nil
+	})
+	if err != nil {
+		log.Info(err)
+	}
+	if count > 0 {
+		log.Infof("Reindexed -> %d stored txs", count)
+	}
+}
+
+func (node *Node) loadCheckpoint() {
+	if node.datadir == "" {
+		return
+	}
+	cp, err := LoadCheckpoint(node.datadir)
+	if err != nil {
+		log.Info("checkpoint is not loaded: ", err)
+		return
+	}
+	node.index.lists = cp.Lists
+	node.index.counter = cp.Counter
+	node.index.stamps = cp.Stamps
+	node.index.sends = cp.Sends
+	// on-disk storage keeps its own state
+	mem, ok := node.storage.(*MemStorage)
+	if ok == true {
+		mem.txs = cp.Txs
+		mem.spent = cp.Spent
+	}
+	node.blockchain.Restore(cp)
+	node.savedheight = cp.Height
+	node.savedtime = time.Now()
+}
+
+// saveCheckpoint writes the index every checkpointBlocks blocks or after
+// checkpointInterval, as encoding it holds the lock for a while. A restart
+// syncs the blocks after the last checkpoint again.
+func (node *Node) saveCheckpoint(block *Block) {
+	if node.datadir == "" {
+		return
+	}
+	if block.Height-node.savedheight < checkpointBlocks && time.Since(node.savedtime) < checkpointInterval {
+		return
+	}
+	headers := node.blockchain.GetHeaders(block.Hash)
+	lock := GetMu()
+	lock.RLock()
+	cp := &Checkpoint{
+		Height:  block.Height,
+		Hash:    block.Hash,
+		Headers: headers,
+		Lists:   node.index.lists,
+		Counter: node.index.counter,
+		Stamps:  node.index.stamps,
+		Sends:   node.index.sends,
+	}
+	mem, ok := node.storage.(*MemStorage)
+	if ok == true {
+		cp.Txs = mem.txs
+		cp.Spent = mem.spent
+	}
+	err := SaveCheckpoint(node.datadir, cp)
+	lock.RUnlock()
+	if err != nil {
+		log.Info(err)
+		return
+	}
+	node.savedheight = block.Height
+	node.savedtime = time.Now()
+	log.Infof("Checkpoint Block# %d Saved", block.Height)
+}
+
 func (node *Node) Start() {
-	node.blockchain.StartSync(3 * time.Second)
-	node.blockchain.StartMemSync(10 * time.Second)
+	notifier, isNotifier := node.blockchain.source.(Notifier)
+	if isNotifier == true {
+		notifier.StartNotify(node.blockchain)
+	}
+	if node.zmqaddr != "" {
+		node.blockchain.StartZMQ(node.zmqaddr)
+	}
+	syncinterval := 3 * time.Second
+	meminterval := 10 * time.Second
+	if node.zmqaddr != "" || isNotifier == true {
+		// notifications drive the sync, polling only catches missed ones
+		syncinterval = 30 * time.Second
+		meminterval = 60 * time.Second
+	}
+	if node.syncinterval > 0 {
+		syncinterval = node.syncinterval
+	}
+	if node.meminterval > 0 {
+		meminterval = node.meminterval
+	}
+	node.blockchain.StartSync(syncinterval)
+	node.blockchain.StartMemSync(meminterval)
 	go node.SubscribeTx()
 	go node.SubscribeBlock()
 
 	loop(func() error {
+		spentCount := node.storage.SpentCount()
+		txCount := node.storage.TxCount()
+		stats := node.blockchain.mempool.Stats()
 		GetMu().RLock()
 		mem := node.blockchain.mempool
 		latestBlock := node.blockchain.GetLatestBlock()
@@ -56,11 +207,22 @@ func (node *Node) Start() {
 		log.Infof(
 			" Pool -> %7d Spent -> %7d Index -> %7d Tx -> %7d",
 			len(mem.pool),
-			len(node.storage.spent),
+			spentCount,
 			len(node.index.stamps),
-			len(node.storage.txs),
+			txCount,
+		)
+		log.Infof(
+			" Mempool queue -> %d running -> %d retrying -> %d fetched -> %d failed -> %d",
+			stats.Queued,
+			stats.Running,
+			stats.Retrying,
+			stats.Fetched,
+			stats.Failed,
 		)
-		log.Info(node.blockchain.blocktimes)
+		tip := node.blockchain.GetTip()
+		if tip != nil {
+			log.Infof(" Tip -> %d %s", tip.Height, tip.Hash)
+		}
 		if len(node.index.lists) >= 6 {
 			for _, m := range node.index.lists[:6] {
 				count := node.index.counter[m.Address]
@@ -75,31 +237,247 @@ func (node *Node) Start() {
 func (node *Node) SubscribeTx() {
 	for {
 		tx := <-node.blockchain.mempool.waitchan
-		node.storage.AddTx(&tx)
-		node.index.AddIn(&tx)
-		addresses := tx.GetOutputsAddresses()
-		for _, addr := range addresses {
-			node.WsPublishMsg(addr, &tx)
-		}
+		node.txmu.Lock()
+		node.addTx(&tx)
+		node.txmu.Unlock()
+	}
+}
+
+func (node *Node) addTx(tx *Tx) {
+	if tx.Status == "" {
+		tx.Status = TxPending
+	}
+	node.resolvePrevouts(tx)
+	if tx.BlockHeight == 0 && tx.Fee != nil {
+		node.blockchain.mempool.SetFee(tx.Txid, tx.Vsize, *tx.Fee)
+	}
+	err := AddTx(node.storage, tx)
+	if err != nil {
+		// the tx has been indexed already
+		log.Debug(err)
+		node.readdTx(tx.Txid)
+		return
+	}
+	node.index.AddIn(tx)
+	node.checkConflicts(tx)
+	for _, addr := range watchKeys(tx) {
+		node.WsPublishMsg(addr, tx)
 	}
 }
 
 func (node *Node) SubscribeBlock() {
 	for {
-		block := <-node.blockchain.waitchan
-		node.index.RemoveIndexWithTxBefore(node.blockchain, node.storage)
-		newTxs := block.UpdateTxs(node.storage)
-		count := 0
-		for _, tx := range newTxs {
-			tx.AddBlockData(&block)
-			tx.Receivedtime = block.Time
-			node.blockchain.mempool.waitchan <- *tx
-			count++
-		}
-		log.Info("news -> ", count)
+		select {
+		case block := <-node.blockchain.waitchan:
+			node.txmu.Lock()
+			node.addBlock(&block)
+			node.txmu.Unlock()
+		case txid := <-node.blockchain.mempool.removechan:
+			node.removed[txid] = node.lastheight
+		case header := <-node.blockchain.rollbackchan:
+			node.txmu.Lock()
+			count := node.rollbackBlock(&header)
+			node.txmu.Unlock()
+			log.Infof("rollback -> %d Block# %d", count, header.Height)
+		}
+	}
+}
+
+// addBlock confirms the stored txs of block and adds the others. It is
+// called with txmu held, so a mempool tx is never added in between.
+func (node *Node) addBlock(block *Block) {
+	node.index.RemoveIndexWithTxBefore(node.blockchain, node.storage)
+	newTxs := block.UpdateTxs(node.storage)
+	count := 0
+	for _, tx := range newTxs {
+		tx.AddBlockData(block)
+		tx.Receivedtime = block.Time
+		node.addTx(tx)
+		count++
+	}
+	log.Info("news -> ", count)
+	node.lastheight = block.Height
+	node.checkDropped()
+	node.saveCheckpoint(block)
+}
+
+// resolvePrevouts fills the values and scripts of the outputs spent by tx,
+// which are needed for the fee and to index the spending addresses
+func (node *Node) resolvePrevouts(tx *Tx) {
+	prevs := make(map[string]*Tx)
+	for _, vin := range tx.Vin {
+		if vin.IsCoinbase() == true || (vin.Value != nil && vin.PrevScriptpubkey != nil) {
+			continue
+		}
+		prev, ok := prevs[vin.Txid]
+		if ok == false {
+			loadTx, err := node.storage.GetTx(vin.Txid)
+			if err != nil && node.prevlookup == true {
+				loadTx, err = node.blockchain.source.GetTx(vin.Txid)
+			}
+			if err != nil {
+				log.Debug(err)
+				continue
+			}
+			prev = loadTx
+			prevs[vin.Txid] = prev
+		}
+		if vin.Vout >= len(prev.Vout) {
+			continue
+		}
+		value := prev.Vout[vin.Vout].Value
+		vin.Value = &value
+		vin.PrevScriptpubkey = prev.Vout[vin.Vout].Scriptpubkey
+	}
+	tx.AddFeeData()
+}
+
+// checkConflicts links tx with the txs spending the same outputs. A pending
+// tx which is double spent is replaced when it signals RBF, otherwise it is
+// conflicted. A confirmed tx is never marked. Subscribers of both sides get a
+// doubleSpend event. It is called with txmu held, so the txs are not
+// confirmed in between reading and writing them back.
+func (node *Node) checkConflicts(tx *Tx) {
+	others := []*Tx{}
+	for _, vin := range tx.Vin {
+		// coinbase inputs spend nothing
+		if vin.IsCoinbase() == true {
+			continue
+		}
+		spents, err := node.storage.GetSpents(vin.Txid + "_" + strconv.Itoa(vin.Vout))
+		if err != nil {
+			continue
+		}
+		for _, txid := range spents {
+			if txid == tx.Txid || checkExist(txid, tx.Conflicts) == true {
+				continue
+			}
+			other, err := node.storage.GetTx(txid)
+			if err != nil {
+				continue
+			}
+			tx.AddConflict(txid)
+			other.AddConflict(tx.Txid)
+			if other.BlockHeight == 0 {
+				other.Status = TxConflicted
+				if other.IsRBF() == true {
+					other.Status = TxReplaced
+				}
+			} else if tx.BlockHeight == 0 {
+				// the spent output is already confirmed
+				tx.Status = TxConflicted
+			}
+			node.storage.UpdateTx(other)
+			others = append(others, other)
+		}
+	}
+	if len(others) == 0 {
+		return
+	}
+	node.storage.UpdateTx(tx)
+	for _, other := range others {
+		log.Infof("Double spend %s %s by %s", other.Txid, other.Status, tx.Txid)
+		for _, addr := range watchKeys(other) {
+			node.wsPublish(DOUBLESPEND, addr, other)
+		}
+	}
+	for _, addr := range watchKeys(tx) {
+		node.wsPublish(DOUBLESPEND, addr, tx)
+	}
+}
+
+// clearConflicts returns the txs conflicted by tx to pending when tx is
+// orphaned. The ones which are not in the mempool are dropped by the next
+// block like a tx which left the mempool.
+func (node *Node) clearConflicts(tx *Tx) {
+	for _, txid := range tx.Conflicts {
+		other, err := node.storage.GetTx(txid)
+		if err != nil || other.BlockHeight > 0 {
+			continue
+		}
+		if other.Status != TxConflicted && other.Status != TxReplaced {
+			continue
+		}
+		other.Status = TxPending
+		node.storage.UpdateTx(other)
+		if node.blockchain.mempool.HasTx(txid) == false {
+			node.removed[txid] = node.lastheight
+		}
+	}
+}
+
+// checkDropped decides the txs which left the mempool before the last block.
+// Mined txs have been confirmed by now and the others were dropped.
+func (node *Node) checkDropped() {
+	for txid, height := range node.removed {
+		if height >= node.lastheight {
+			continue
+		}
+		delete(node.removed, txid)
+		if node.blockchain.mempool.HasTx(txid) == true {
+			continue
+		}
+		tx, err := node.storage.GetTx(txid)
+		if err != nil || tx.BlockHeight > 0 {
+			continue
+		}
+		if tx.Status == TxReplaced || tx.Status == TxConflicted {
+			// reported by checkConflicts
+			continue
+		}
+		tx.Status = TxDropped
+		node.storage.UpdateTx(tx)
+		log.Infof("Tx %s %s", tx.Status, txid)
+		for _, addr := range watchKeys(tx) {
+			node.wsPublish(TXDROPPED, addr, tx)
+		}
 	}
 }
 
+// readdTx marks a dropped tx as pending again when it returns to the mempool
+func (node *Node) readdTx(txid string) {
+	tx, err := node.storage.GetTx(txid)
+	if err != nil {
+		return
+	}
+	if tx.Status != TxDropped && tx.Status != TxReplaced {
+		return
+	}
+	tx.Status = TxPending
+	node.storage.UpdateTx(tx)
+}
+
+// rollbackBlock returns the txs of an orphaned block and the txs they
+// conflicted to the unconfirmed state
+func (node *Node) rollbackBlock(header *Header) int {
+	count := 0
+	for _, txid := range header.Txids {
+		tx, err := node.storage.GetTx(txid)
+		if err != nil {
+			continue
+		}
+		if tx.BlockHash != header.Hash {
+			continue
+		}
+		tx.RemoveBlockData()
+		if tx.CheckCoinbase() == true {
+			// a coinbase is only valid in its block and never returns to
+			// the mempool
+			tx.Status = TxDropped
+		}
+		node.storage.UpdateTx(tx)
+		node.clearConflicts(tx)
+		if tx.Status == TxDropped {
+			log.Infof("Tx %s %s", tx.Status, txid)
+			for _, addr := range watchKeys(tx) {
+				node.wsPublish(TXDROPPED, addr, tx)
+			}
+		}
+		count++
+	}
+	return count
+}
+
 func (node *Node) GetIndex(w rest.ResponseWriter, r *rest.Request) {
 	address := r.PathParam("address")
 	stamps := node.index.GetStamps(address)
@@ -114,6 +492,7 @@ func (node *Node) GetTx(w rest.ResponseWriter, r *rest.Request) {
 		res500(w, r)
 		return
 	}
+	tx.UpdateConfirmations(node.blockchain.GetLatestBlock())
 	w.WriteHeader(http.StatusOK)
 	w.WriteJson(tx)
 }
@@ -169,6 +548,10 @@ func (node *Node) GetTxs(w rest.ResponseWriter, r *rest.Request) {
 		}
 		resTxs = resTxs[p:limit]
 	}
+	tip := node.blockchain.GetLatestBlock()
+	for _, tx := range resTxs {
+		tx.UpdateConfirmations(tip)
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.WriteJson(resTxs)
@@ -247,6 +630,10 @@ func (node *Node) WsHandler(w http.ResponseWriter, r *http.Request) {
 					resTxs = append(resTxs, txs[i])
 				}
 			}
+			tip := node.blockchain.GetLatestBlock()
+			for _, tx := range resTxs {
+				tx.UpdateConfirmations(tip)
+			}
 			type Payload struct {
 				Action  string
 				Address string
@@ -258,19 +645,76 @@ func (node *Node) WsHandler(w http.ResponseWriter, r *http.Request) {
 				log.Info(err)
 			}
 			client.Send(bytes)
+		case GETBALANCE:
+			balance, err := node.index.GetBalance(msg.Address, node.storage, node.blockchain.GetLatestBlock())
+			if err != nil {
+				break
+			}
+			type Payload struct {
+				Action  string
+				Address string
+				Balance *Balance
+			}
+			bytes, err := json.Marshal(Payload{GETBALANCE, msg.Address, balance})
+			if err != nil {
+				log.Info(err)
+			}
+			client.Send(bytes)
+		case GETUTXOS:
+			utxos, err := node.index.GetUtxos(msg.Address, node.storage, node.blockchain.GetLatestBlock())
+			if err != nil {
+				break
+			}
+			type Payload struct {
+				Action  string
+				Address string
+				Utxos   []*Utxo
+			}
+			bytes, err := json.Marshal(Payload{GETUTXOS, msg.Address, utxos})
+			if err != nil {
+				log.Info(err)
+			}
+			client.Send(bytes)
 		default:
 			break
 		}
 	}
 }
 
+// watchKeys returns the addresses and the script hashes of the outputs and
+// of the resolved prevouts of tx, as txs can be watched by either and both
+// the payer and the payee are notified
+func watchKeys(tx *Tx) []string {
+	keys := []string{}
+	lists := [][]string{
+		tx.GetOutputsAddresses(),
+		tx.GetOutputsScripthashes(),
+		tx.GetInputsAddresses(),
+		tx.GetInputsScripthashes(),
+	}
+	for _, list := range lists {
+		for _, key := range list {
+			if checkExist(key, keys) == true {
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 func (node *Node) WsPublishMsg(addr string, tx *Tx) {
+	node.wsPublish(WATCHTXS, addr, tx)
+}
+
+func (node *Node) wsPublish(action string, addr string, tx *Tx) {
 	type Payload struct {
 		Action  string
 		Address string
 		Tx      *Tx
 	}
-	payload := Payload{"watchTxs", addr, tx}
+	tx.UpdateConfirmations(node.blockchain.GetLatestBlock())
+	payload := Payload{action, addr, tx}
 	bytes, err := json.Marshal(payload)
 	if err != nil {
 		log.Info(err)
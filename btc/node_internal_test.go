@@ -0,0 +1,99 @@
+package btc
+
+import (
+	"testing"
+)
+
+func newTestNode() *Node {
+	return NewNode(&Config{Source: &fakeSource{}, PruneBlocks: 4, Storage: NewMemStorage()})
+}
+
+// testSpend returns a tx spending the output vout of prev to an OP_RETURN
+func testSpend(txid string, prev string, vout int, sequence int64) *Tx {
+	return &Tx{
+		Txid: txid,
+		Hash: txid,
+		Vin:  []*Vin{{Txid: prev, Vout: vout, Sequence: sequence}},
+		Vout: []*Vout{{Value: 1000, Scriptpubkey: &ScriptPubkey{Hex: "6a", Keytype: "nulldata", Addresses: []string{}}}},
+	}
+}
+
+func assertStatus(t *testing.T, node *Node, txid string, status string, height int64) *Tx {
+	t.Helper()
+	tx, err := node.storage.GetTx(txid)
+	if err != nil {
+		t.Fatalf("%s: %v", txid, err)
+	}
+	if tx.Status != status || tx.BlockHeight != height {
+		t.Errorf("%s is %s at %d, want %s at %d", txid, tx.Status, tx.BlockHeight, status, height)
+	}
+	return tx
+}
+
+func TestNodeConflicts(t *testing.T) {
+	node := newTestNode()
+	parent, _ := loadTxFixture(t, "getrawtransaction_spend_v23.json")
+	parent.AddBlockData(&Block{Hash: "block5", Height: 5})
+	node.addTx(parent)
+
+	// a pending tx double spent by another pending tx is conflicted, or
+	// replaced when it signals RBF
+	node.addTx(testSpend("a", spendTxid, 0, 0xffffffff))
+	node.addTx(testSpend("b", spendTxid, 0, 0xffffffff))
+	a := assertStatus(t, node, "a", TxConflicted, 0)
+	b := assertStatus(t, node, "b", TxPending, 0)
+	if checkExist("b", a.Conflicts) == false || checkExist("a", b.Conflicts) == false {
+		t.Errorf("conflicts of a %v and b %v", a.Conflicts, b.Conflicts)
+	}
+	node.addTx(testSpend("r1", spendTxid, 3, 0xfffffffd))
+	node.addTx(testSpend("r2", spendTxid, 3, 0xfffffffd))
+	assertStatus(t, node, "r1", TxReplaced, 0)
+	assertStatus(t, node, "r2", TxPending, 0)
+
+	// the payer is notified through the spent script
+	keys := watchKeys(a)
+	scripthash := parent.Vout[0].Scriptpubkey.Scripthash()
+	if checkExist(scripthash, keys) == false || checkExist(parent.Vout[0].Scriptpubkey.Address, keys) == false {
+		t.Errorf("watch keys %v miss the prevout %s", keys, scripthash)
+	}
+
+	// a mined tx is never marked, a tx spending its input is conflicted
+	node.addBlock(&Block{Hash: "block6", Height: 6, Txs: []*Tx{testSpend("b", spendTxid, 0, 0xffffffff)}})
+	node.addTx(testSpend("c", spendTxid, 0, 0xffffffff))
+	assertStatus(t, node, "b", TxConfirmed, 6)
+	assertStatus(t, node, "c", TxConflicted, 0)
+
+	// a block tx conflicts the pending txs spending the same output
+	node.addBlock(&Block{Hash: "block7", Height: 7, Txs: []*Tx{testSpend("d", spendTxid, 3, 0xffffffff)}})
+	assertStatus(t, node, "d", TxConfirmed, 7)
+	assertStatus(t, node, "r2", TxReplaced, 0)
+
+	// orphaning the block returns the txs it conflicted to pending, and as
+	// they are not in the mempool they are dropped by the next block
+	node.rollbackBlock(&Header{Hash: "block6", Height: 6, Txids: []string{"b"}})
+	assertStatus(t, node, "b", TxPending, 0)
+	assertStatus(t, node, "a", TxPending, 0)
+	assertStatus(t, node, "c", TxPending, 0)
+	assertStatus(t, node, "d", TxConfirmed, 7)
+	node.addBlock(&Block{Hash: "block8", Height: 8})
+	assertStatus(t, node, "a", TxDropped, 0)
+	assertStatus(t, node, "c", TxDropped, 0)
+}
+
+func TestNodeOrphanedCoinbase(t *testing.T) {
+	node := newTestNode()
+	coinbase, _ := loadTxFixture(t, "getrawtransaction_coinbase_v23.json")
+	block := &Block{Hash: "block5", Height: 5, Txs: []*Tx{coinbase}}
+	node.addBlock(block)
+	assertStatus(t, node, coinbaseTxid, TxConfirmed, 5)
+
+	// an orphaned coinbase is dropped instead of waiting in the mempool
+	node.rollbackBlock(&Header{Hash: "block5", Height: 5, Txids: []string{coinbaseTxid}})
+	assertStatus(t, node, coinbaseTxid, TxDropped, 0)
+	node.addBlock(&Block{Hash: "block6", Height: 6})
+	assertStatus(t, node, coinbaseTxid, TxDropped, 0)
+
+	// and confirmed again when its block returns to the main chain
+	node.addBlock(block)
+	assertStatus(t, node, coinbaseTxid, TxConfirmed, 5)
+}
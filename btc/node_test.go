@@ -0,0 +1,109 @@
+package btc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SwingbyProtocol/tx-indexer/bitcoindtest"
+	"github.com/SwingbyProtocol/tx-indexer/btc"
+)
+
+const testAddress = "mkHS9ne12qx9pS9VojpwU5xtRd4T7X7ZUt"
+
+// waitFor polls check until it holds. step runs between the polls, e.g. to
+// mine blocks.
+func waitFor(t *testing.T, what string, timeout time.Duration, check func() bool, step func()) {
+	deadline := time.Now().Add(timeout)
+	for check() == false {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		if step != nil {
+			step()
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func txStatus(storage btc.Storage, txid string) (string, int64) {
+	tx, err := storage.GetTx(txid)
+	if err != nil {
+		return "", 0
+	}
+	return tx.Status, tx.BlockHeight
+}
+
+// TestNodeSync runs a node against the fake bitcoind through block
+// indexing, a reorg and a mempool drop. The sync polls every 50ms, so it
+// takes about a second.
+func TestNodeSync(t *testing.T) {
+	srv := bitcoindtest.NewServer("regtest")
+	defer srv.Close()
+	// a height zero tip is not synced
+	first := srv.Mine()
+	storage := btc.NewMemStorage()
+	node := btc.NewNode(&btc.Config{
+		Source:      btc.NewRESTSource([]string{srv.URL}, false),
+		PruneBlocks: 100,
+		Storage:     storage,
+		// the coinbase of the first block is spent but not indexed
+		PrevoutLookup:   true,
+		SyncInterval:    50 * time.Millisecond,
+		MempoolInterval: 50 * time.Millisecond,
+	})
+	node.Start()
+
+	// a tx mined after the start is indexed as confirmed
+	spend := bitcoindtest.NewTx([]*btc.Vin{bitcoindtest.Input(first.Txs[0].Txid, 0)}, bitcoindtest.Output(testAddress, 49*1e8))
+	block := srv.Mine(spend)
+	waitFor(t, "the block tx", 5*time.Second, func() bool {
+		status, height := txStatus(storage, spend.Txid)
+		return status == btc.TxConfirmed && height == block.Height
+	}, nil)
+	tx, err := storage.GetTx(spend.Txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.BlockHash != block.Hash || tx.Vin[0].Value == nil || *tx.Vin[0].Value != 50*1e8 {
+		t.Errorf("tx block %s, want %s, prevout value %v", tx.BlockHash, block.Hash, tx.Vin[0].Value)
+	}
+
+	// the block is orphaned by a longer chain and its tx returns to the
+	// mempool until it is mined again
+	srv.Reorg(1)
+	srv.Mine()
+	srv.Mine()
+	waitFor(t, "the rollback", 5*time.Second, func() bool {
+		status, height := txStatus(storage, spend.Txid)
+		return status == btc.TxPending && height == 0
+	}, nil)
+	block = srv.MineMempool()
+	waitFor(t, "the tx mined again", 5*time.Second, func() bool {
+		status, height := txStatus(storage, spend.Txid)
+		return status == btc.TxConfirmed && height == block.Height
+	}, nil)
+
+	// a mempool tx which is evicted is dropped once a block has been found
+	// without it
+	pending := bitcoindtest.NewTx([]*btc.Vin{bitcoindtest.Input(spend.Txid, 0)}, bitcoindtest.Output(testAddress, 48*1e8))
+	srv.AddMempoolTx(pending)
+	waitFor(t, "the mempool tx", 5*time.Second, func() bool {
+		status, _ := txStatus(storage, pending.Txid)
+		return status == btc.TxPending
+	}, nil)
+	srv.RemoveMempoolTx(pending.Txid)
+	mined := time.Now()
+	waitFor(t, "the drop", 5*time.Second, func() bool {
+		status, _ := txStatus(storage, pending.Txid)
+		return status == btc.TxDropped
+	}, func() {
+		if time.Since(mined) > 200*time.Millisecond {
+			srv.Mine()
+			mined = time.Now()
+		}
+	})
+	status, height := txStatus(storage, spend.Txid)
+	if status != btc.TxConfirmed || height != block.Height {
+		t.Errorf("mined tx is %s at %d after the drop", status, height)
+	}
+}
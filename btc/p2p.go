@@ -0,0 +1,534 @@
+package btc
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SwingbyProtocol/tx-indexer/p2p"
+	"github.com/SwingbyProtocol/tx-indexer/resolver"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	p2pTimeout = 30 * time.Second
+	// bitcoind evicts txs after two weeks (-mempoolexpiry)
+	p2pPoolExpiry = 14 * 24 * time.Hour
+	// announced txs waiting to be indexed
+	p2pTxQueue = 10000
+	// how often the announced txs are requested again to prune the ones
+	// which have left the mempool of the peer
+	p2pPoolRefresh = 10 * time.Minute
+	// the most entries of an inv or getdata message
+	p2pMaxInv = 50000
+)
+
+// P2PSource loads chain data from a bitcoin node over the P2P wire protocol,
+// so neither REST nor RPC has to be exposed. Headers are synced from genesis
+// to resolve heights (about 36 bytes per block are kept in memory), blocks and
+// txs are requested with getdata and txs announced by the peer are pushed to
+// the mempool as soon as they arrive. The peer only announces new txs, so
+// txs which were in its mempool before the connection are not indexed. The
+// announced txs are requested again every p2pPoolRefresh, and the ones the
+// peer no longer has, because they were evicted, replaced or expired, are
+// removed from the pool.
+type P2PSource struct {
+	addr    string
+	params  *Params
+	peer    *p2p.Peer
+	dial    func() (*p2p.Peer, error)
+	headers []p2pHeader
+	synced  bool
+	pool    map[string]int64
+	// pool txs requested again by refreshPool
+	refresh map[string]bool
+	waiters map[string][]chan []byte
+	chain   *BlockChain
+	txqueue chan []byte
+	mu      sync.RWMutex
+}
+
+type p2pHeader struct {
+	hash [32]byte
+	time uint32
+}
+
+func NewP2PSource(addr string, params *Params) *P2PSource {
+	genesis, _ := hashFromHex(params.GenesisHash)
+	return &P2PSource{
+		addr:   addr,
+		params: params,
+		dial: func() (*p2p.Peer, error) {
+			return p2p.Connect(addr, params.Net, 10*time.Second)
+		},
+		// the genesis time only affects the mediantime of the first blocks
+		headers: []p2pHeader{{hash: genesis}},
+		pool:    make(map[string]int64),
+		refresh: make(map[string]bool),
+		waiters: make(map[string][]chan []byte),
+		txqueue: make(chan []byte, p2pTxQueue),
+	}
+}
+
+// StartNotify connects to the peer and keeps the connection open
+func (s *P2PSource) StartNotify(b *BlockChain) {
+	s.mu.Lock()
+	s.chain = b
+	s.mu.Unlock()
+	go s.doAddTxs(b)
+	go s.doConnect()
+	go s.doRefreshPool()
+}
+
+// doAddTxs indexes the announced txs off the read loop, which has to keep
+// delivering the replies to GetTx while the node is busy
+func (s *P2PSource) doAddTxs(b *BlockChain) {
+	for payload := range s.txqueue {
+		err := b.mempool.AddRawTx(payload, s.params)
+		if err != nil {
+			log.Info(err)
+		}
+	}
+}
+
+func (s *P2PSource) doRefreshPool() {
+	for {
+		time.Sleep(p2pPoolRefresh)
+		err := s.refreshPool()
+		if err != nil {
+			log.Info("p2p: ", err)
+		}
+	}
+}
+
+// refreshPool requests the pool txs again. bitcoind serves every tx of its
+// mempool which is older than two minutes, so the ones it replies notfound
+// for have left it and are removed by handleNotFound.
+func (s *P2PSource) refreshPool() error {
+	s.mu.Lock()
+	peer := s.peer
+	if peer == nil {
+		s.mu.Unlock()
+		return errors.New("p2p peer is not connected")
+	}
+	invs := []p2p.InvVect{}
+	for txid := range s.pool {
+		hash, err := hashFromHex(txid)
+		if err != nil {
+			continue
+		}
+		s.refresh[txid] = true
+		invs = append(invs, p2p.InvVect{Type: p2p.InvTypeWitnessTx, Hash: hash})
+	}
+	s.mu.Unlock()
+	for len(invs) > 0 {
+		count := len(invs)
+		if count > p2pMaxInv {
+			count = p2pMaxInv
+		}
+		err := peer.WriteMessage("getdata", p2p.EncodeInv(invs[:count]))
+		if err != nil {
+			return err
+		}
+		invs = invs[count:]
+	}
+	return nil
+}
+
+func (s *P2PSource) doConnect() {
+	for {
+		err := s.connect()
+		log.Info("p2p: ", err)
+		s.mu.Lock()
+		s.peer = nil
+		s.refresh = make(map[string]bool)
+		for hash, chans := range s.waiters {
+			for _, ch := range chans {
+				close(ch)
+			}
+			delete(s.waiters, hash)
+		}
+		s.mu.Unlock()
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (s *P2PSource) connect() error {
+	peer, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer peer.Close()
+	log.Infof("p2p connected -> %s %s Block# %d", s.addr, peer.UserAgent, peer.StartHeight)
+	// announce new blocks with headers instead of inv
+	err = peer.WriteMessage("sendheaders", nil)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.peer = peer
+	s.mu.Unlock()
+	err = s.requestHeaders(peer)
+	if err != nil {
+		return err
+	}
+	for {
+		cmd, payload, err := peer.ReadMessage()
+		if err != nil {
+			return err
+		}
+		switch cmd {
+		case "headers":
+			err = s.handleHeaders(peer, payload)
+		case "inv":
+			err = s.handleInv(peer, payload)
+		case "tx":
+			err = s.handleTx(payload)
+		case "block":
+			s.handleBlock(payload)
+		case "notfound":
+			err = s.handleNotFound(payload)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *P2PSource) requestHeaders(peer *p2p.Peer) error {
+	s.mu.RLock()
+	locator := s.locator()
+	s.mu.RUnlock()
+	return peer.WriteMessage("getheaders", p2p.EncodeGetHeaders(locator, [32]byte{}))
+}
+
+// locator returns hashes from the tip back to genesis, dense at first and
+// then doubling the step
+func (s *P2PSource) locator() [][32]byte {
+	locator := [][32]byte{}
+	step := 1
+	for i := len(s.headers) - 1; i > 0; i -= step {
+		locator = append(locator, s.headers[i].hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	return append(locator, s.headers[0].hash)
+}
+
+func (s *P2PSource) handleHeaders(peer *p2p.Peer, payload []byte) error {
+	headers, err := p2p.DecodeHeaders(payload)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for _, header := range headers {
+		var prev [32]byte
+		copy(prev[:], header[4:36])
+		height := s.heightOf(prev)
+		if height < 0 {
+			// not connected to our chain, ask again with our locator
+			s.mu.Unlock()
+			return s.requestHeaders(peer)
+		}
+		if height != int64(len(s.headers)-1) {
+			log.Warnf("p2p: reorg below Block# %d", height+1)
+			s.headers = s.headers[:height+1]
+		}
+		s.headers = append(s.headers, p2pHeader{
+			hash: p2p.BlockHash(header),
+			time: binary.LittleEndian.Uint32(header[68:72]),
+		})
+	}
+	tip := len(s.headers) - 1
+	chain := s.chain
+	more := len(headers) == 2000
+	if more == false && s.synced == false {
+		s.synced = true
+		log.Infof("p2p headers synced Block# %d", tip)
+	}
+	synced := s.synced
+	s.mu.Unlock()
+	if more == true {
+		if tip%100000 < 2000 {
+			log.Infof("p2p headers Block# %d", tip)
+		}
+		return s.requestHeaders(peer)
+	}
+	if synced == true && len(headers) > 0 && chain != nil {
+		chain.Notify()
+	}
+	return nil
+}
+
+func (s *P2PSource) handleInv(peer *p2p.Peer, payload []byte) error {
+	invs, err := p2p.DecodeInv(payload)
+	if err != nil {
+		return err
+	}
+	requests := []p2p.InvVect{}
+	newBlock := false
+	s.mu.RLock()
+	for _, inv := range invs {
+		switch inv.Type {
+		case p2p.InvTypeTx, p2p.InvTypeWitnessTx:
+			_, ok := s.pool[reverseHex(inv.Hash[:])]
+			if ok == false {
+				requests = append(requests, p2p.InvVect{Type: p2p.InvTypeWitnessTx, Hash: inv.Hash})
+			}
+		case p2p.InvTypeBlock, p2p.InvTypeWitnessBlock:
+			newBlock = true
+		}
+	}
+	s.mu.RUnlock()
+	if newBlock == true {
+		err := s.requestHeaders(peer)
+		if err != nil {
+			return err
+		}
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	return peer.WriteMessage("getdata", p2p.EncodeInv(requests))
+}
+
+func (s *P2PSource) handleTx(payload []byte) error {
+	tx, err := DecodeTx(payload, s.params)
+	if err != nil {
+		return err
+	}
+	if s.deliver(tx.Txid, payload) == true {
+		// requested by GetTx
+		return nil
+	}
+	s.mu.Lock()
+	if s.refresh[tx.Txid] == true {
+		// still in the mempool of the peer
+		delete(s.refresh, tx.Txid)
+		s.mu.Unlock()
+		return nil
+	}
+	s.pool[tx.Txid] = time.Now().Unix()
+	chain := s.chain
+	s.mu.Unlock()
+	if chain == nil {
+		return nil
+	}
+	select {
+	case s.txqueue <- payload:
+	default:
+		// the tx is in the pool, so the mempool sync fetches it later
+		log.Debugf("p2p: tx queue is full, %s is left to the mempool sync", tx.Txid)
+	}
+	return nil
+}
+
+func (s *P2PSource) handleBlock(payload []byte) {
+	if len(payload) < 80 {
+		return
+	}
+	hash := p2p.BlockHash(payload[:80])
+	s.deliver(reverseHex(hash[:]), payload)
+}
+
+// handleNotFound fails the getdata requests and removes the txs from the
+// pool, as the peer does not have them anymore
+func (s *P2PSource) handleNotFound(payload []byte) error {
+	invs, err := p2p.DecodeInv(payload)
+	if err != nil {
+		return err
+	}
+	for _, inv := range invs {
+		hash := reverseHex(inv.Hash[:])
+		s.deliver(hash, nil)
+		if inv.Type != p2p.InvTypeTx && inv.Type != p2p.InvTypeWitnessTx {
+			continue
+		}
+		s.mu.Lock()
+		delete(s.pool, hash)
+		delete(s.refresh, hash)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// deliver hands data to the pending getdata requests for hash. nil means
+// not found.
+func (s *P2PSource) deliver(hash string, data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chans, ok := s.waiters[hash]
+	if ok == false {
+		return false
+	}
+	for _, ch := range chans {
+		ch <- data
+	}
+	delete(s.waiters, hash)
+	return true
+}
+
+func (s *P2PSource) getData(invType uint32, hash string) ([]byte, error) {
+	h, err := hashFromHex(hash)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []byte, 1)
+	s.mu.Lock()
+	peer := s.peer
+	if peer == nil {
+		s.mu.Unlock()
+		return nil, errors.New("p2p peer is not connected")
+	}
+	s.waiters[hash] = append(s.waiters[hash], ch)
+	s.mu.Unlock()
+	err = peer.WriteMessage("getdata", p2p.EncodeInv([]p2p.InvVect{{Type: invType, Hash: h}}))
+	if err != nil {
+		s.removeWaiter(hash, ch)
+		return nil, err
+	}
+	select {
+	case data, ok := <-ch:
+		if ok == false {
+			return nil, errors.New("p2p peer disconnected")
+		}
+		if data == nil {
+			return nil, fmt.Errorf("p2p getdata %s: %w", hash, resolver.ErrNotFound)
+		}
+		return data, nil
+	case <-time.After(p2pTimeout):
+		s.removeWaiter(hash, ch)
+		return nil, fmt.Errorf("p2p getdata %s: %w", hash, resolver.ErrTimeout)
+	}
+}
+
+func (s *P2PSource) removeWaiter(hash string, ch chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chans := []chan []byte{}
+	for _, c := range s.waiters[hash] {
+		if c != ch {
+			chans = append(chans, c)
+		}
+	}
+	if len(chans) == 0 {
+		delete(s.waiters, hash)
+		return
+	}
+	s.waiters[hash] = chans
+}
+
+// heightOf searches the header chain from the tip, where lookups usually hit
+func (s *P2PSource) heightOf(hash [32]byte) int64 {
+	for i := len(s.headers) - 1; i >= 0; i-- {
+		if s.headers[i].hash == hash {
+			return int64(i)
+		}
+	}
+	return -1
+}
+
+func (s *P2PSource) medianTime(height int64) int64 {
+	times := []int{}
+	for i := height; i >= 0 && i > height-11; i-- {
+		times = append(times, int(s.headers[i].time))
+	}
+	sort.Ints(times)
+	return int64(times[len(times)/2])
+}
+
+func (s *P2PSource) GetChainInfo() (*ChainInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.synced == false {
+		return nil, errors.New("p2p headers are syncing")
+	}
+	tip := s.headers[len(s.headers)-1]
+	return &ChainInfo{
+		Chain:         s.params.Name,
+		Blocks:        int64(len(s.headers) - 1),
+		Headers:       int64(len(s.headers) - 1),
+		Bestblockhash: reverseHex(tip.hash[:]),
+	}, nil
+}
+
+func (s *P2PSource) GetBlockHash(height int64) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if height < 0 || height >= int64(len(s.headers)) {
+		return "", fmt.Errorf("p2p Block# %d: %w", height, resolver.ErrNotFound)
+	}
+	return reverseHex(s.headers[height].hash[:]), nil
+}
+
+func (s *P2PSource) GetBlock(hash string) (*Block, error) {
+	data, err := s.getData(p2p.InvTypeWitnessBlock, hash)
+	if err != nil {
+		return nil, err
+	}
+	block, err := DecodeBlock(data, s.params)
+	if err != nil {
+		return nil, err
+	}
+	h, _ := hashFromHex(hash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	height := s.heightOf(h)
+	if height < 0 {
+		return nil, errors.New("p2p block is not in the header chain " + hash)
+	}
+	block.Height = height
+	block.Mediantime = s.medianTime(height)
+	block.Confirmations = int64(len(s.headers)) - height
+	for _, tx := range block.Txs {
+		delete(s.pool, tx.Txid)
+	}
+	return block, nil
+}
+
+// GetTx only finds txs in the mempool of the peer
+func (s *P2PSource) GetTx(txid string) (*Tx, error) {
+	data, err := s.getData(p2p.InvTypeWitnessTx, txid)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeTx(data, s.params)
+}
+
+// GetMempool returns the txs announced by the peer which it still has
+func (s *P2PSource) GetMempool() (map[string]PoolTx, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	height := int64(len(s.headers) - 1)
+	expiry := time.Now().Add(-p2pPoolExpiry).Unix()
+	res := make(map[string]PoolTx)
+	for txid, t := range s.pool {
+		if t < expiry {
+			delete(s.pool, txid)
+			continue
+		}
+		res[txid] = PoolTx{Height: height, Time: t}
+	}
+	return res, nil
+}
+
+// hashFromHex converts a hash in rpc byte order to wire byte order
+func hashFromHex(hash string) ([32]byte, error) {
+	res := [32]byte{}
+	b, err := hex.DecodeString(hash)
+	if err != nil {
+		return res, err
+	}
+	if len(b) != 32 {
+		return res, errors.New("hash is invalid " + hash)
+	}
+	for i := range b {
+		res[i] = b[31-i]
+	}
+	return res, nil
+}
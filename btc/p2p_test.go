@@ -0,0 +1,185 @@
+package btc
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SwingbyProtocol/tx-indexer/p2p"
+	"github.com/SwingbyProtocol/tx-indexer/resolver"
+)
+
+// expectMessage reads the next message of the fake node
+func expectMessage(t *testing.T, node *p2p.Peer, command string) []byte {
+	t.Helper()
+	cmd, payload, err := node.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != command {
+		t.Fatalf("command %s, want %s", cmd, command)
+	}
+	return payload
+}
+
+// expectGetData reads a getdata message and returns the requested txids
+func expectGetData(t *testing.T, node *p2p.Peer) []string {
+	t.Helper()
+	invs, err := p2p.DecodeInv(expectMessage(t, node, "getdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	txids := []string{}
+	for _, inv := range invs {
+		if inv.Type != p2p.InvTypeWitnessTx {
+			t.Errorf("getdata type %x", inv.Type)
+		}
+		txids = append(txids, reverseHex(inv.Hash[:]))
+	}
+	return txids
+}
+
+func txInvs(t *testing.T, txids ...string) []p2p.InvVect {
+	invs := []p2p.InvVect{}
+	for _, txid := range txids {
+		hash, err := hashFromHex(txid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		invs = append(invs, p2p.InvVect{Type: p2p.InvTypeTx, Hash: hash})
+	}
+	return invs
+}
+
+func assertP2PPool(t *testing.T, s *P2PSource, txids ...string) {
+	t.Helper()
+	pool, err := s.GetMempool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool) != len(txids) {
+		t.Fatalf("pool %+v, want %v", pool, txids)
+	}
+	for _, txid := range txids {
+		_, ok := pool[txid]
+		if ok == false {
+			t.Errorf("%s is not in the pool", txid)
+		}
+	}
+}
+
+// TestP2PSourcePool runs the source against a fake node over net.Pipe: the
+// announced txs are requested and pooled, and the ones the node replies
+// notfound for on a refresh are pruned
+func TestP2PSourcePool(t *testing.T) {
+	spend, spendRaw := loadTxFixture(t, "getrawtransaction_spend_v23.json")
+	coinbase, coinbaseRaw := loadTxFixture(t, "getrawtransaction_coinbase_v23.json")
+	raws := map[string][]byte{spend.Txid: spendRaw, coinbase.Txid: coinbaseRaw}
+	params := RegTestParams
+	local, remote := net.Pipe()
+	s := NewP2PSource("pipe", params)
+	s.dial = func() (*p2p.Peer, error) {
+		return p2p.Handshake(local, params.Net, time.Second)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.connect()
+	}()
+	node, err := p2p.Accept(remote, params.Net, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	expectMessage(t, node, "sendheaders")
+	expectMessage(t, node, "getheaders")
+	// no headers after genesis
+	err = node.WriteMessage("headers", []byte{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// announced txs are requested
+	err = node.WriteMessage("inv", p2p.EncodeInv(txInvs(t, spend.Txid, coinbase.Txid)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, txid := range expectGetData(t, node) {
+		err := node.WriteMessage("tx", raws[txid])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// getdata replies go to GetTx and not to the pool
+	res := make(chan error, 1)
+	go func() {
+		tx, err := s.GetTx(spend.Txid)
+		if err == nil && tx.Txid != spend.Txid {
+			err = errors.New("GetTx " + tx.Txid)
+		}
+		res <- err
+	}()
+	txids := expectGetData(t, node)
+	if len(txids) != 1 || txids[0] != spend.Txid {
+		t.Fatalf("getdata %v", txids)
+	}
+	err = node.WriteMessage("tx", spendRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-res
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertP2PPool(t, s, spend.Txid, coinbase.Txid)
+
+	// the node still has the spend, but no longer the coinbase
+	go func() {
+		res <- s.refreshPool()
+	}()
+	txids = expectGetData(t, node)
+	if len(txids) != 2 {
+		t.Fatalf("refresh %v", txids)
+	}
+	err = <-res
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = node.WriteMessage("tx", spendRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = node.WriteMessage("notfound", p2p.EncodeInv(txInvs(t, coinbase.Txid)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a notfound reply fails GetTx, and is read after the refresh replies
+	go func() {
+		_, err := s.GetTx(coinbase.Txid)
+		res <- err
+	}()
+	expectGetData(t, node)
+	err = node.WriteMessage("notfound", p2p.EncodeInv(txInvs(t, coinbase.Txid)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-res
+	if errors.Is(err, resolver.ErrNotFound) == false {
+		t.Errorf("GetTx of a tx the node does not have: %v", err)
+	}
+	assertP2PPool(t, s, spend.Txid)
+	s.mu.RLock()
+	refresh := len(s.refresh)
+	s.mu.RUnlock()
+	if refresh != 0 {
+		t.Errorf("%d txs are left to refresh", refresh)
+	}
+
+	node.Close()
+	err = <-errs
+	if err == nil {
+		t.Error("connection closed without an error")
+	}
+}
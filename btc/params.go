@@ -0,0 +1,50 @@
+package btc
+
+// Params holds the address encoding and the p2p network of a chain
+type Params struct {
+	Name             string
+	PubKeyHashPrefix byte
+	ScriptHashPrefix byte
+	Bech32HRP        string
+	Net              uint32
+	DefaultPort      string
+	GenesisHash      string
+}
+
+var (
+	MainNetParams = &Params{
+		"main", 0x00, 0x05, "bc", 0xd9b4bef9, "8333",
+		"000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+	}
+	TestNetParams = &Params{
+		"test", 0x6f, 0xc4, "tb", 0x0709110b, "18333",
+		"000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+	}
+	TestNet4Params = &Params{
+		"testnet4", 0x6f, 0xc4, "tb", 0x283f161c, "48333",
+		"00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043",
+	}
+	SigNetParams = &Params{
+		"signet", 0x6f, 0xc4, "tb", 0x40cf030a, "38333",
+		"00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6",
+	}
+	RegTestParams = &Params{
+		"regtest", 0x6f, 0xc4, "bcrt", 0xdab5bffa, "18444",
+		"0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+	}
+)
+
+// GetParams returns the params for the chain name reported by getblockchaininfo
+func GetParams(chain string) *Params {
+	switch chain {
+	case "test":
+		return TestNetParams
+	case "testnet4":
+		return TestNet4Params
+	case "signet":
+		return SigNetParams
+	case "regtest":
+		return RegTestParams
+	}
+	return MainNetParams
+}
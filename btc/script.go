@@ -0,0 +1,326 @@
+package btc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	opPushData1     = 0x4c
+	opPushData2     = 0x4d
+	opPushData4     = 0x4e
+	op1             = 0x51
+	op16            = 0x60
+	opReturn        = 0x6a
+	opDup           = 0x76
+	opEqual         = 0x87
+	opEqualVerify   = 0x88
+	opHash160       = 0xa9
+	opCheckSig      = 0xac
+	opCheckMultiSig = 0xae
+)
+
+var opNames = map[byte]string{
+	0x00: "0", 0x4c: "OP_PUSHDATA1", 0x4d: "OP_PUSHDATA2", 0x4e: "OP_PUSHDATA4", 0x4f: "-1",
+	0x50: "OP_RESERVED", 0x61: "OP_NOP", 0x62: "OP_VER", 0x63: "OP_IF", 0x64: "OP_NOTIF",
+	0x65: "OP_VERIF", 0x66: "OP_VERNOTIF", 0x67: "OP_ELSE", 0x68: "OP_ENDIF", 0x69: "OP_VERIFY",
+	0x6a: "OP_RETURN", 0x6b: "OP_TOALTSTACK", 0x6c: "OP_FROMALTSTACK", 0x6d: "OP_2DROP",
+	0x6e: "OP_2DUP", 0x6f: "OP_3DUP", 0x70: "OP_2OVER", 0x71: "OP_2ROT", 0x72: "OP_2SWAP",
+	0x73: "OP_IFDUP", 0x74: "OP_DEPTH", 0x75: "OP_DROP", 0x76: "OP_DUP", 0x77: "OP_NIP",
+	0x78: "OP_OVER", 0x79: "OP_PICK", 0x7a: "OP_ROLL", 0x7b: "OP_ROT", 0x7c: "OP_SWAP",
+	0x7d: "OP_TUCK", 0x7e: "OP_CAT", 0x7f: "OP_SUBSTR", 0x80: "OP_LEFT", 0x81: "OP_RIGHT",
+	0x82: "OP_SIZE", 0x83: "OP_INVERT", 0x84: "OP_AND", 0x85: "OP_OR", 0x86: "OP_XOR",
+	0x87: "OP_EQUAL", 0x88: "OP_EQUALVERIFY", 0x89: "OP_RESERVED1", 0x8a: "OP_RESERVED2",
+	0x8b: "OP_1ADD", 0x8c: "OP_1SUB", 0x8d: "OP_2MUL", 0x8e: "OP_2DIV", 0x8f: "OP_NEGATE",
+	0x90: "OP_ABS", 0x91: "OP_NOT", 0x92: "OP_0NOTEQUAL", 0x93: "OP_ADD", 0x94: "OP_SUB",
+	0x95: "OP_MUL", 0x96: "OP_DIV", 0x97: "OP_MOD", 0x98: "OP_LSHIFT", 0x99: "OP_RSHIFT",
+	0x9a: "OP_BOOLAND", 0x9b: "OP_BOOLOR", 0x9c: "OP_NUMEQUAL", 0x9d: "OP_NUMEQUALVERIFY",
+	0x9e: "OP_NUMNOTEQUAL", 0x9f: "OP_LESSTHAN", 0xa0: "OP_GREATERTHAN",
+	0xa1: "OP_LESSTHANOREQUAL", 0xa2: "OP_GREATERTHANOREQUAL", 0xa3: "OP_MIN", 0xa4: "OP_MAX",
+	0xa5: "OP_WITHIN", 0xa6: "OP_RIPEMD160", 0xa7: "OP_SHA1", 0xa8: "OP_SHA256",
+	0xa9: "OP_HASH160", 0xaa: "OP_HASH256", 0xab: "OP_CODESEPARATOR", 0xac: "OP_CHECKSIG",
+	0xad: "OP_CHECKSIGVERIFY", 0xae: "OP_CHECKMULTISIG", 0xaf: "OP_CHECKMULTISIGVERIFY",
+	0xb0: "OP_NOP1", 0xb1: "OP_CHECKLOCKTIMEVERIFY", 0xb2: "OP_CHECKSEQUENCEVERIFY",
+	0xb3: "OP_NOP4", 0xb4: "OP_NOP5", 0xb5: "OP_NOP6", 0xb6: "OP_NOP7", 0xb7: "OP_NOP8",
+	0xb8: "OP_NOP9", 0xb9: "OP_NOP10", 0xba: "OP_CHECKSIGADD",
+}
+
+type scriptOp struct {
+	code byte
+	data []byte
+	push bool
+}
+
+func parseScript(script []byte) ([]scriptOp, error) {
+	ops := []scriptOp{}
+	for i := 0; i < len(script); {
+		code := script[i]
+		i++
+		if code > opPushData4 {
+			ops = append(ops, scriptOp{code: code})
+			continue
+		}
+		size := int(code)
+		switch code {
+		case opPushData1:
+			if i+1 > len(script) {
+				return ops, errors.New("script push is out of range")
+			}
+			size = int(script[i])
+			i++
+		case opPushData2:
+			if i+2 > len(script) {
+				return ops, errors.New("script push is out of range")
+			}
+			size = int(script[i]) | int(script[i+1])<<8
+			i += 2
+		case opPushData4:
+			if i+4 > len(script) {
+				return ops, errors.New("script push is out of range")
+			}
+			size = int(script[i]) | int(script[i+1])<<8 | int(script[i+2])<<16 | int(script[i+3])<<24
+			i += 4
+		}
+		if size < 0 || i+size > len(script) {
+			return ops, errors.New("script push is out of range")
+		}
+		ops = append(ops, scriptOp{code: code, data: script[i : i+size], push: true})
+		i += size
+	}
+	return ops, nil
+}
+
+var sighashNames = map[byte]string{
+	0x01: "ALL",
+	0x02: "NONE",
+	0x03: "SINGLE",
+	0x81: "ALL|ANYONECANPAY",
+	0x82: "NONE|ANYONECANPAY",
+	0x83: "SINGLE|ANYONECANPAY",
+}
+
+// ScriptToAsm formats a script the way bitcoind does for scriptPubKey.asm
+func ScriptToAsm(script []byte) string {
+	return scriptToAsm(script, false)
+}
+
+// ScriptSigToAsm formats a script the way bitcoind does for scriptSig.asm,
+// where the sighash type of a signature is written as [ALL] and so on
+func ScriptSigToAsm(script []byte) string {
+	return scriptToAsm(script, true)
+}
+
+func scriptToAsm(script []byte, sighash bool) string {
+	ops, err := parseScript(script)
+	parts := []string{}
+	for _, op := range ops {
+		if op.push == true {
+			if len(op.data) <= 4 {
+				parts = append(parts, strconv.FormatInt(scriptNum(op.data), 10))
+				continue
+			}
+			name, ok := sighashNames[op.data[len(op.data)-1]]
+			if sighash == true && ok == true && isDERSignature(op.data) == true {
+				parts = append(parts, hex.EncodeToString(op.data[:len(op.data)-1])+"["+name+"]")
+				continue
+			}
+			parts = append(parts, hex.EncodeToString(op.data))
+			continue
+		}
+		parts = append(parts, opName(op.code))
+	}
+	if err != nil {
+		parts = append(parts, "[error]")
+	}
+	return strings.Join(parts, " ")
+}
+
+// isDERSignature checks the strict DER encoding of BIP 66 of a signature
+// followed by its sighash type
+func isDERSignature(sig []byte) bool {
+	size := len(sig)
+	if size < 9 || size > 73 || sig[0] != 0x30 || int(sig[1]) != size-3 {
+		return false
+	}
+	lenR := int(sig[3])
+	if 5+lenR >= size {
+		return false
+	}
+	lenS := int(sig[5+lenR])
+	if lenR+lenS+7 != size || sig[2] != 0x02 || lenR == 0 || sig[4]&0x80 != 0 {
+		return false
+	}
+	if lenR > 1 && sig[4] == 0 && sig[5]&0x80 == 0 {
+		return false
+	}
+	if sig[lenR+4] != 0x02 || lenS == 0 || sig[lenR+6]&0x80 != 0 {
+		return false
+	}
+	if lenS > 1 && sig[lenR+6] == 0 && sig[lenR+7]&0x80 == 0 {
+		return false
+	}
+	return true
+}
+
+func opName(code byte) string {
+	if code >= op1 && code <= op16 {
+		return strconv.Itoa(int(code - op1 + 1))
+	}
+	name, ok := opNames[code]
+	if ok == false {
+		return "OP_UNKNOWN"
+	}
+	return name
+}
+
+func scriptNum(data []byte) int64 {
+	if len(data) == 0 {
+		return 0
+	}
+	res := int64(0)
+	for i, b := range data {
+		res |= int64(b) << uint(8*i)
+	}
+	last := data[len(data)-1]
+	if last&0x80 != 0 {
+		return -(res & ^(int64(0x80) << uint(8*(len(data)-1))))
+	}
+	return res
+}
+
+// NewScriptPubkey classifies the script and derives its address with the
+// same type names as bitcoind
+func NewScriptPubkey(script []byte, params *Params) *ScriptPubkey {
+	spk := &ScriptPubkey{
+		Asm:       ScriptToAsm(script),
+		Hex:       hex.EncodeToString(script),
+		Keytype:   "nonstandard",
+		Addresses: []string{},
+	}
+	size := len(script)
+	switch {
+	case size == 25 && script[0] == opDup && script[1] == opHash160 && script[2] == 20 && script[23] == opEqualVerify && script[24] == opCheckSig:
+		spk.Keytype = "pubkeyhash"
+		spk.Address = EncodeBase58Check(params.PubKeyHashPrefix, script[3:23])
+	case size == 23 && script[0] == opHash160 && script[1] == 20 && script[22] == opEqual:
+		spk.Keytype = "scripthash"
+		spk.Address = EncodeBase58Check(params.ScriptHashPrefix, script[2:22])
+	case isWitnessProgram(script):
+		version := 0
+		if script[0] != 0 {
+			version = int(script[0]-op1) + 1
+		}
+		program := script[2:]
+		switch {
+		case version == 0 && len(program) == 20:
+			spk.Keytype = "witness_v0_keyhash"
+		case version == 0 && len(program) == 32:
+			spk.Keytype = "witness_v0_scripthash"
+		case version == 0:
+			return spk
+		case version == 1 && len(program) == 32:
+			spk.Keytype = "witness_v1_taproot"
+		default:
+			spk.Keytype = "witness_unknown"
+		}
+		addr, err := EncodeSegwitAddress(params.Bech32HRP, version, program)
+		if err == nil {
+			spk.Address = addr
+		}
+	case size > 0 && script[0] == opReturn && isPushOnly(script[1:]):
+		spk.Keytype = "nulldata"
+		return spk
+	case (size == 35 && script[0] == 33 || size == 67 && script[0] == 65) && script[size-1] == opCheckSig:
+		spk.Keytype = "pubkey"
+		return spk
+	default:
+		m, ok := multisigRequired(script)
+		if ok == true {
+			spk.Keytype = "multisig"
+			spk.Reqsigs = m
+		}
+		return spk
+	}
+	if spk.Address != "" {
+		spk.Addresses = []string{spk.Address}
+	}
+	spk.Reqsigs = 1
+	return spk
+}
+
+// Scripthash returns the script hash used by Electrum, the sha256 of the
+// script in reversed byte order
+func Scripthash(script []byte) string {
+	sum := sha256.Sum256(script)
+	for i, j := 0, len(sum)-1; i < j; i, j = i+1, j-1 {
+		sum[i], sum[j] = sum[j], sum[i]
+	}
+	return hex.EncodeToString(sum[:])
+}
+
+// ToScripthash resolves an address to the script hash it is indexed by.
+// Script hashes are returned as they are.
+func ToScripthash(addr string) string {
+	if len(addr) == 64 {
+		_, err := hex.DecodeString(addr)
+		if err == nil {
+			return strings.ToLower(addr)
+		}
+	}
+	script, err := DecodeAddress(addr)
+	if err != nil {
+		return addr
+	}
+	return Scripthash(script)
+}
+
+func isWitnessProgram(script []byte) bool {
+	if len(script) < 4 || len(script) > 42 {
+		return false
+	}
+	if script[0] != 0 && (script[0] < op1 || script[0] > op16) {
+		return false
+	}
+	return int(script[1])+2 == len(script)
+}
+
+func isPushOnly(script []byte) bool {
+	ops, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if op.push == false && op.code > op16 {
+			return false
+		}
+	}
+	return true
+}
+
+func multisigRequired(script []byte) (int, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 {
+		return 0, false
+	}
+	first := ops[0]
+	last := ops[len(ops)-1]
+	count := ops[len(ops)-2]
+	if last.code != opCheckMultiSig || first.code < op1 || first.code > op16 || count.code < op1 || count.code > op16 {
+		return 0, false
+	}
+	keys := ops[1 : len(ops)-2]
+	m := int(first.code-op1) + 1
+	n := int(count.code-op1) + 1
+	if len(keys) != n || m > n {
+		return 0, false
+	}
+	for _, key := range keys {
+		if key.push == false || (len(key.data) != 33 && len(key.data) != 65) {
+			return 0, false
+		}
+	}
+	return m, true
+}
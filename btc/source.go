@@ -0,0 +1,257 @@
+package btc
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SwingbyProtocol/tx-indexer/resolver"
+)
+
+// Source is the transport used by BlockChain and Mempool to load chain data
+type Source interface {
+	GetChainInfo() (*ChainInfo, error)
+	GetBlockHash(height int64) (string, error)
+	GetBlock(hash string) (*Block, error)
+	GetTx(txid string) (*Tx, error)
+	GetMempool() (map[string]PoolTx, error)
+}
+
+// Notifier is implemented by sources which push new blocks and txs
+type Notifier interface {
+	StartNotify(b *BlockChain)
+}
+
+// RESTSource loads chain data from the bitcoind REST interface (-rest). With
+// several endpoints requests fail over to the healthiest one, and with
+// crosscheck a block is only accepted when all healthy endpoints agree on it.
+type RESTSource struct {
+	resolver   *resolver.Resolver
+	crosscheck bool
+	binary     bool
+	params     *Params
+	mu         sync.RWMutex
+}
+
+// blockInfo is a block without txs as returned by /rest/block/notxdetails
+type blockInfo struct {
+	Hash          string `json:"hash"`
+	Confirmations int64  `json:"confirmations"`
+	Height        int64  `json:"height"`
+	Mediantime    int64  `json:"mediantime"`
+}
+
+func NewRESTSource(uris []string, crosscheck bool) *RESTSource {
+	return &RESTSource{
+		resolver:   resolver.NewMultiResolver(uris),
+		crosscheck: crosscheck,
+	}
+}
+
+// EnableBinary loads blocks and txs from the .bin endpoints, which are
+// smaller and faster to decode than .json
+func (s *RESTSource) EnableBinary() {
+	s.binary = true
+}
+
+// Resolver is used to record or replay the responses
+func (s *RESTSource) Resolver() *resolver.Resolver {
+	return s.resolver
+}
+
+func (s *RESTSource) StartHealthCheck(t time.Duration) {
+	s.resolver.StartHealthCheck(t, func(e *resolver.Endpoint) (int64, error) {
+		info := ChainInfo{}
+		err := s.resolver.GetRequestFrom(e, "/rest/chaininfo.json", &info)
+		if err != nil {
+			return 0, err
+		}
+		return info.Blocks, nil
+	})
+}
+
+func (s *RESTSource) GetChainInfo() (*ChainInfo, error) {
+	info := ChainInfo{}
+	err := s.resolver.GetRequest("/rest/chaininfo.json", &info)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.params = GetParams(info.Chain)
+	s.mu.Unlock()
+	return &info, nil
+}
+
+func (s *RESTSource) getParams() (*Params, error) {
+	s.mu.RLock()
+	params := s.params
+	s.mu.RUnlock()
+	if params != nil {
+		return params, nil
+	}
+	_, err := s.GetChainInfo()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	params = s.params
+	s.mu.RUnlock()
+	return params, nil
+}
+
+func (s *RESTSource) GetBlockHash(height int64) (string, error) {
+	res := BlockHash{}
+	err := s.resolver.GetRequest("/rest/blockhashbyheight/"+strconv.FormatInt(height, 10)+".json", &res)
+	if err != nil {
+		return "", err
+	}
+	return res.Blockhash, nil
+}
+
+func (s *RESTSource) GetBlock(hash string) (*Block, error) {
+	block := &Block{}
+	var err error
+	if s.binary == true {
+		block, err = s.getBinaryBlock(hash)
+	} else {
+		err = s.resolver.GetRequest("/rest/block/"+hash+".json", block)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.crosscheck == true {
+		err := s.checkBlockHash(block.Height, block.Hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+func (s *RESTSource) getBinaryBlock(hash string) (*Block, error) {
+	params, err := s.getParams()
+	if err != nil {
+		return nil, err
+	}
+	info := blockInfo{}
+	err = s.resolver.GetRequest("/rest/block/notxdetails/"+hash+".json", &info)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.resolver.GetRawRequest("/rest/block/" + hash + ".bin")
+	if err != nil {
+		return nil, err
+	}
+	block, err := DecodeBlock(data, params)
+	if err != nil {
+		return nil, err
+	}
+	if block.Hash != hash {
+		return nil, errors.New("Block hash mismatch " + hash + " -> " + block.Hash)
+	}
+	block.Height = info.Height
+	block.Mediantime = info.Mediantime
+	block.Confirmations = info.Confirmations
+	return block, nil
+}
+
+func (s *RESTSource) checkBlockHash(height int64, hash string) error {
+	query := "/rest/blockhashbyheight/" + strconv.FormatInt(height, 10) + ".json"
+	for _, e := range s.resolver.Endpoints() {
+		if e.Healthy == false {
+			continue
+		}
+		res := BlockHash{}
+		err := s.resolver.GetRequestFrom(e, query, &res)
+		if errors.Is(err, resolver.ErrNotFound) {
+			// the endpoint has not reached this height yet
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if res.Blockhash != hash {
+			return errors.New("Block# " + strconv.FormatInt(height, 10) + " mismatch " + hash + " " + e.URI + " -> " + res.Blockhash)
+		}
+	}
+	return nil
+}
+
+func (s *RESTSource) GetTx(txid string) (*Tx, error) {
+	if s.binary == true {
+		params, err := s.getParams()
+		if err != nil {
+			return nil, err
+		}
+		data, err := s.resolver.GetRawRequest("/rest/tx/" + txid + ".bin")
+		if err != nil {
+			return nil, err
+		}
+		return DecodeTx(data, params)
+	}
+	tx := Tx{}
+	err := s.resolver.GetRequest("/rest/tx/"+txid+".json", &tx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+func (s *RESTSource) GetMempool() (map[string]PoolTx, error) {
+	res := make(map[string]PoolTx)
+	err := s.resolver.GetRequest("/rest/mempool/contents.json", &res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RPCSource loads chain data from the bitcoind JSON-RPC interface
+type RPCSource struct {
+	client *resolver.RPCClient
+}
+
+func NewRPCSource(client *resolver.RPCClient) *RPCSource {
+	return &RPCSource{client: client}
+}
+
+func (s *RPCSource) GetChainInfo() (*ChainInfo, error) {
+	info := ChainInfo{}
+	err := s.client.GetBlockchainInfo(&info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (s *RPCSource) GetBlockHash(height int64) (string, error) {
+	return s.client.GetBlockHash(height)
+}
+
+func (s *RPCSource) GetBlock(hash string) (*Block, error) {
+	block := Block{}
+	err := s.client.GetBlock(hash, 2, &block)
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (s *RPCSource) GetTx(txid string) (*Tx, error) {
+	tx := Tx{}
+	err := s.client.GetRawTransaction(txid, true, &tx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+func (s *RPCSource) GetMempool() (map[string]PoolTx, error) {
+	res := make(map[string]PoolTx)
+	err := s.client.GetRawMempool(true, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,42 @@
+package btc
+
+import (
+	"github.com/SwingbyProtocol/tx-indexer/resolver"
+)
+
+// fakeSource reports a fixed chain tip and mempool, and serves the txs in
+// its txs map. It has no blocks.
+type fakeSource struct {
+	info ChainInfo
+	pool map[string]PoolTx
+	txs  map[string]*Tx
+}
+
+func (s *fakeSource) GetChainInfo() (*ChainInfo, error) {
+	info := s.info
+	return &info, nil
+}
+
+func (s *fakeSource) GetBlockHash(height int64) (string, error) {
+	return "", resolver.ErrNotFound
+}
+
+func (s *fakeSource) GetBlock(hash string) (*Block, error) {
+	return nil, resolver.ErrNotFound
+}
+
+func (s *fakeSource) GetTx(txid string) (*Tx, error) {
+	tx, ok := s.txs[txid]
+	if ok == false {
+		return nil, resolver.ErrNotFound
+	}
+	return copyTx(tx), nil
+}
+
+func (s *fakeSource) GetMempool() (map[string]PoolTx, error) {
+	res := make(map[string]PoolTx)
+	for txid, entry := range s.pool {
+		res[txid] = entry
+	}
+	return res, nil
+}
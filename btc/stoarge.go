@@ -5,19 +5,63 @@ import (
 	"strconv"
 )
 
-type Storage struct {
+type Storage interface {
+	GetTx(txid string) (*Tx, error)
+	UpdateTx(tx *Tx)
+	DeleteTx(txid string)
+	AddSpent(key string, txid string) error
+	GetSpents(key string) ([]string, error)
+	DeleteSpent(key string)
+	ForEachTx(f func(tx *Tx) error) error
+	ForEachSpent(f func(key string, txids []string) error) error
+	TxCount() int
+	SpentCount() int
+	Close() error
+}
+
+func AddTx(s Storage, tx *Tx) error {
+	tx.IsCoinbase = tx.CheckCoinbase()
+	if tx.IsCoinbase == true {
+		// a coinbase spends nothing, so there is no spent key to detect it
+		// being indexed twice
+		_, err := s.GetTx(tx.Txid)
+		if err == nil {
+			return errors.New("tx is already indexed")
+		}
+	}
+	for _, vin := range tx.Vin {
+		if vin.IsCoinbase() == true {
+			continue
+		}
+		key := vin.Txid + "_" + strconv.Itoa(vin.Vout)
+		err := s.AddSpent(key, tx.Txid)
+		if err != nil {
+			return err
+		}
+	}
+	for _, vout := range tx.Vout {
+		vout.Txs = []string{}
+	}
+	if tx.Conflicts == nil {
+		tx.Conflicts = []string{}
+	}
+	s.UpdateTx(tx)
+	return nil
+}
+
+type MemStorage struct {
 	txs   map[string]*Tx
 	spent map[string][]string
 }
 
-func NewStorage() *Storage {
-	return &Storage{
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
 		txs:   make(map[string]*Tx),
 		spent: make(map[string][]string),
 	}
 }
 
-func (s *Storage) GetTx(txid string) (*Tx, error) {
+func (s *MemStorage) GetTx(txid string) (*Tx, error) {
 	lock := GetMu()
 	lock.RLock()
 	tx, ok := s.txs[txid]
@@ -25,10 +69,10 @@ func (s *Storage) GetTx(txid string) (*Tx, error) {
 	if ok == false {
 		return nil, errors.New("tx is not exist")
 	}
-	return tx, nil
+	return copyTx(tx), nil
 }
 
-func (s *Storage) GetSpents(key string) ([]string, error) {
+func (s *MemStorage) GetSpents(key string) ([]string, error) {
 	lock := GetMu()
 	lock.RLock()
 	spents, ok := s.spent[key]
@@ -42,7 +86,7 @@ func (s *Storage) GetSpents(key string) ([]string, error) {
 	return spents, nil
 }
 
-func (s *Storage) AddSpent(key string, txid string) error {
+func (s *MemStorage) AddSpent(key string, txid string) error {
 	lock := GetMu()
 	lock.RLock()
 	spents, _ := s.spent[key]
@@ -56,42 +100,91 @@ func (s *Storage) AddSpent(key string, txid string) error {
 	return nil
 }
 
-func (s *Storage) DeleteSpent(key string) {
+func (s *MemStorage) DeleteSpent(key string) {
 	lock := GetMu()
 	lock.Lock()
 	delete(s.spent, key)
 	lock.Unlock()
 }
 
-func (s *Storage) AddTx(tx *Tx) error {
-	for _, vin := range tx.Vin {
-		key := vin.Txid + "_" + strconv.Itoa(vin.Vout)
-		err := s.AddSpent(key, tx.Txid)
+func (s *MemStorage) DeleteTx(txid string) {
+	lock := GetMu()
+	lock.Lock()
+	delete(s.txs, txid)
+	lock.Unlock()
+}
+
+func (s *MemStorage) UpdateTx(tx *Tx) {
+	lock := GetMu()
+	tx = copyTx(tx)
+	lock.Lock()
+	s.txs[tx.Txid] = tx
+	lock.Unlock()
+}
+
+// copyTx copies the fields which are changed after a tx is stored, so the
+// stored tx is only changed by UpdateTx like with an on-disk storage
+func copyTx(tx *Tx) *Tx {
+	res := *tx
+	res.Vout = make([]*Vout, len(tx.Vout))
+	for i, vout := range tx.Vout {
+		v := *vout
+		res.Vout[i] = &v
+	}
+	res.Conflicts = append([]string{}, tx.Conflicts...)
+	return &res
+}
+
+func (s *MemStorage) ForEachTx(f func(tx *Tx) error) error {
+	lock := GetMu()
+	lock.RLock()
+	txs := make([]*Tx, 0, len(s.txs))
+	for _, tx := range s.txs {
+		txs = append(txs, copyTx(tx))
+	}
+	lock.RUnlock()
+	for _, tx := range txs {
+		err := f(tx)
 		if err != nil {
 			return err
 		}
 	}
-	for _, vout := range tx.Vout {
-		_, ok := vout.Value.(float64)
-		if ok == true {
-			vout.Value = strconv.FormatFloat(vout.Value.(float64), 'f', -1, 64)
+	return nil
+}
+
+func (s *MemStorage) ForEachSpent(f func(key string, txids []string) error) error {
+	lock := GetMu()
+	lock.RLock()
+	spent := make(map[string][]string, len(s.spent))
+	for key, txids := range s.spent {
+		spent[key] = txids
+	}
+	lock.RUnlock()
+	for key, txids := range spent {
+		err := f(key, txids)
+		if err != nil {
+			return err
 		}
-		vout.Txs = []string{}
 	}
-	s.UpdateTx(tx)
 	return nil
 }
 
-func (s *Storage) DeleteTx(txid string) {
+func (s *MemStorage) TxCount() int {
 	lock := GetMu()
-	lock.Lock()
-	delete(s.txs, txid)
-	lock.Unlock()
+	lock.RLock()
+	count := len(s.txs)
+	lock.RUnlock()
+	return count
 }
 
-func (s *Storage) UpdateTx(tx *Tx) {
+func (s *MemStorage) SpentCount() int {
 	lock := GetMu()
-	lock.Lock()
-	s.txs[tx.Txid] = tx
-	lock.Unlock()
+	lock.RLock()
+	count := len(s.spent)
+	lock.RUnlock()
+	return count
+}
+
+func (s *MemStorage) Close() error {
+	return nil
 }
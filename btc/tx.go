@@ -1,80 +1,276 @@
 package btc
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"math"
 	"strconv"
 
-	"github.com/SwingbyProtocol/tx-indexer/resolver"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	TxPending   = "pending"
+	TxConfirmed = "confirmed"
+	TxDropped   = "dropped"
+	TxReplaced  = "replaced"
+	// double spent by a tx without opting in to replace-by-fee
+	TxConflicted = "conflicted"
+	// confirmations needed before coinbase outputs can be spent
+	CoinbaseMaturity = 100
+)
+
 type Txs struct {
 	txs map[string]*Tx
 }
 
 type Tx struct {
-	Txid         string  `json:"txid"`
-	Hash         string  `json:"hash"`
-	Confirms     int64   `json:"confirms"`
-	Receivedtime int64   `json:"receivedtime"`
-	MinedTime    int64   `json:"minedtime"`
-	Mediantime   int64   `json:"mediantime"`
-	Version      int     `json:"version"`
-	Weight       int     `json:"weight"`
-	Locktime     int     `json:"locktime"`
-	Vin          []*Vin  `json:"vin"`
-	Vout         []*Vout `json:"vout"`
+	Txid          string   `json:"txid"`
+	Hash          string   `json:"hash"`
+	BlockHeight   int64    `json:"blockHeight"`
+	BlockHash     string   `json:"blockHash"`
+	Confirmations int64    `json:"confirmations"`
+	IsCoinbase    bool     `json:"isCoinbase"`
+	Mature        bool     `json:"mature"`
+	Status        string   `json:"status"`
+	Receivedtime  int64    `json:"receivedtime"`
+	MinedTime     int64    `json:"minedtime"`
+	Mediantime    int64    `json:"mediantime"`
+	Version       int      `json:"version"`
+	Weight        int      `json:"weight"`
+	Vsize         int      `json:"vsize"`
+	Fee           *int64   `json:"-"`
+	Feerate       *float64 `json:"feerate,omitempty"`
+	Locktime      int      `json:"locktime"`
+	Vin           []*Vin   `json:"vin"`
+	Vout          []*Vout  `json:"vout"`
+	Conflicts     []string `json:"conflicts"`
 	//Hex      string  `json:"hex"`
 }
 
+// Vin.Value is the value of the prevout in satoshis, nil until it is
+// resolved. It is written as value (BTC) and valueSat.
 type Vin struct {
-	Txid     string `json:"txid"`
-	Vout     int    `json:"vout"`
-	Sequence int64  `json:"sequence"`
+	Txid string `json:"txid,omitempty"`
+	Vout int    `json:"vout"`
+	// the script of a coinbase input, in place of Txid and ScriptSig
+	Coinbase    string     `json:"coinbase,omitempty"`
+	ScriptSig   *ScriptSig `json:"scriptSig,omitempty"`
+	Txinwitness []string   `json:"txinwitness,omitempty"`
+	Sequence    int64      `json:"sequence"`
+	Value       *int64     `json:"-"`
+	// the scriptPubKey of the prevout, nil until it is resolved
+	PrevScriptpubkey *ScriptPubkey `json:"prevScriptPubKey,omitempty"`
+}
+
+// IsCoinbase reports whether vin is the input of a coinbase tx, which
+// spends no output
+func (vin *Vin) IsCoinbase() bool {
+	return vin.Coinbase != "" || vin.Txid == ""
+}
+
+type ScriptSig struct {
+	Asm string `json:"asm"`
+	Hex string `json:"hex"`
 }
 
+// Vout.Value is in satoshis. It is written as value (BTC) and valueSat.
 type Vout struct {
-	Value        interface{}   `json:"value"`
+	Value        int64         `json:"-"`
 	Spent        bool          `json:"spent"`
 	Txs          []string      `json:"txs"`
 	N            int           `json:"n"`
 	Scriptpubkey *ScriptPubkey `json:"scriptPubkey"`
 }
 
+// ScriptPubkey is written with both the address of bitcoind 22+ and the
+// addresses and reqSigs of the earlier versions
 type ScriptPubkey struct {
 	Asm       string   `json:"asm"`
+	Desc      string   `json:"desc,omitempty"`
 	Hex       string   `json:"hex"`
 	Reqsigs   int      `json:"reqSigs"`
 	Keytype   string   `json:"type"`
+	Address   string   `json:"address,omitempty"`
 	Addresses []string `json:"addresses"`
 }
 
-func (tx *Tx) AddTxData(r *resolver.Resolver) error {
-	err := r.GetRequest("/rest/tx/"+tx.Txid+".json", tx)
+// UnmarshalJSON reads the layouts of all bitcoind versions. bitcoind 22+
+// dropped addresses and reqSigs, which are filled in from address and the
+// script.
+func (spk *ScriptPubkey) UnmarshalJSON(data []byte) error {
+	type alias ScriptPubkey
+	err := json.Unmarshal(data, (*alias)(spk))
+	if err != nil {
+		return err
+	}
+	if spk.Address == "" && len(spk.Addresses) == 1 {
+		spk.Address = spk.Addresses[0]
+	}
+	if spk.Address != "" && len(spk.Addresses) == 0 {
+		spk.Addresses = []string{spk.Address}
+	}
+	if spk.Addresses == nil {
+		spk.Addresses = []string{}
+	}
+	if spk.Reqsigs == 0 && spk.Address != "" {
+		spk.Reqsigs = 1
+	}
+	if spk.Reqsigs == 0 && spk.Keytype == "multisig" {
+		script, err := hex.DecodeString(spk.Hex)
+		if err == nil {
+			spk.Reqsigs, _ = multisigRequired(script)
+		}
+	}
+	return nil
+}
+
+// Scripthash returns the script hash of the script, or "" when the script
+// is not known
+func (spk *ScriptPubkey) Scripthash() string {
+	if spk == nil {
+		return ""
+	}
+	script, err := hex.DecodeString(spk.Hex)
+	if err != nil || len(script) == 0 {
+		return ""
+	}
+	return Scripthash(script)
+}
+
+// Scripthashes returns the script hashes the output is indexed by. bitcoind
+// before 22.0 reported the P2PKH address of the key for a P2PK output, so
+// that address is indexed as an alias and keeps finding the output.
+func (spk *ScriptPubkey) Scripthashes() []string {
+	scripthash := spk.Scripthash()
+	if scripthash == "" {
+		return nil
+	}
+	scripthashes := []string{scripthash}
+	if spk.Keytype == "pubkey" && len(spk.Addresses) == 1 {
+		alias := ToScripthash(spk.Addresses[0])
+		if alias != spk.Addresses[0] && alias != scripthash {
+			scripthashes = append(scripthashes, alias)
+		}
+	}
+	return scripthashes
+}
+
+// HasScripthash reports whether the output is indexed by scripthash
+func (spk *ScriptPubkey) HasScripthash(scripthash string) bool {
+	return scripthash != "" && checkExist(scripthash, spk.Scripthashes()) == true
+}
+
+func (tx *Tx) AddTxData(source Source) error {
+	res, err := source.GetTx(tx.Txid)
 	if err != nil {
 		return err
 	}
+	res.Receivedtime = tx.Receivedtime
+	*tx = *res
 	return nil
 }
 
 func (tx *Tx) AddBlockData(block *Block) *Tx {
-	tx.Confirms = block.Height
+	tx.BlockHeight = block.Height
+	tx.BlockHash = block.Hash
+	tx.Status = TxConfirmed
 	tx.MinedTime = block.Time
 	tx.Mediantime = block.Mediantime
 	return tx
 }
 
-func (tx *Tx) EnableTxSpent(addr string, storage *Storage) {
+// UpdateConfirmations counts the confirmations up to the tip height. It is
+// called when the tx is served, so the count follows the chain and reorgs.
+// The outputs of a coinbase tx mature after CoinbaseMaturity confirmations.
+func (tx *Tx) UpdateConfirmations(tip int64) *Tx {
+	tx.Confirmations = 0
+	if tx.BlockHeight > 0 && tip >= tx.BlockHeight {
+		tx.Confirmations = tip - tx.BlockHeight + 1
+	}
+	tx.IsCoinbase = tx.CheckCoinbase()
+	tx.Mature = tx.IsCoinbase == false || tx.Confirmations >= CoinbaseMaturity
+	return tx
+}
+
+// CheckCoinbase reports whether tx is the coinbase tx of a block
+func (tx *Tx) CheckCoinbase() bool {
+	return len(tx.Vin) == 1 && tx.Vin[0].IsCoinbase()
+}
+
+// AddFeeData computes the vsize and, once the values of all prevouts are
+// known, the fee in satoshis and the fee rate in sat/vB. The fee stays nil
+// while it is unknown, unless bitcoind reported it with the block.
+func (tx *Tx) AddFeeData() *Tx {
+	if tx.Weight > 0 {
+		tx.Vsize = (tx.Weight + 3) / 4
+	}
+	known := len(tx.Vin) > 0
+	in := int64(0)
+	for _, vin := range tx.Vin {
+		// coinbase txs pay no fee
+		if vin.IsCoinbase() == true {
+			return tx
+		}
+		if vin.Value == nil {
+			known = false
+			break
+		}
+		in += *vin.Value
+	}
+	if known == true {
+		out := int64(0)
+		for _, vout := range tx.Vout {
+			out += vout.Value
+		}
+		fee := in - out
+		tx.Fee = &fee
+	}
+	if tx.Fee != nil && tx.Vsize > 0 {
+		feerate := math.Round(float64(*tx.Fee)/float64(tx.Vsize)*1000) / 1000
+		tx.Feerate = &feerate
+	}
+	return tx
+}
+
+// IsRBF reports whether the tx signals replaceability (BIP125)
+func (tx *Tx) IsRBF() bool {
+	for _, vin := range tx.Vin {
+		if vin.Sequence < 0xfffffffe {
+			return true
+		}
+	}
+	return false
+}
+
+func (tx *Tx) AddConflict(txid string) {
+	if checkExist(txid, tx.Conflicts) == true {
+		return
+	}
+	tx.Conflicts = append(tx.Conflicts, txid)
+}
+
+func (tx *Tx) RemoveBlockData() *Tx {
+	tx.BlockHeight = 0
+	tx.BlockHash = ""
+	tx.Confirmations = 0
+	tx.Status = TxPending
+	tx.MinedTime = 0
+	tx.Mediantime = 0
+	return tx
+}
+
+// EnableTxSpent adds the spending txs to the outputs to addr, which is an
+// address or a script hash
+func (tx *Tx) EnableTxSpent(addr string, storage Storage) {
+	scripthash := ToScripthash(addr)
 	for i, vout := range tx.Vout {
 		key := tx.Txid + "_" + strconv.Itoa(i)
 		spents, err := storage.GetSpents(key)
 		if err != nil {
 			continue
 		}
-		if len(vout.Scriptpubkey.Addresses) != 1 {
-			continue
-		}
-		address := vout.Scriptpubkey.Addresses[0]
-		if addr != address {
+		if vout.Scriptpubkey.HasScripthash(scripthash) == false {
 			continue
 		}
 		vout.Spent = true
@@ -82,10 +278,11 @@ func (tx *Tx) EnableTxSpent(addr string, storage *Storage) {
 	}
 }
 
-func (tx *Tx) CheckAllSpent(storage *Storage) bool {
+func (tx *Tx) CheckAllSpent(storage Storage) bool {
 	isAllSpent := true
 	for i, vout := range tx.Vout {
-		if len(vout.Scriptpubkey.Addresses) != 1 {
+		// unspendable
+		if vout.Scriptpubkey == nil || vout.Scriptpubkey.Keytype == "nulldata" {
 			continue
 		}
 		key := tx.Txid + "_" + strconv.Itoa(i)
@@ -113,3 +310,49 @@ func (tx *Tx) GetOutputsAddresses() []string {
 	}
 	return addresses
 }
+
+// GetOutputsScripthashes returns the script hashes of all outputs, including
+// the ones without an address such as bare multisig and OP_RETURN
+func (tx *Tx) GetOutputsScripthashes() []string {
+	scripthashes := []string{}
+	for _, vout := range tx.Vout {
+		for _, scripthash := range vout.Scriptpubkey.Scripthashes() {
+			if checkExist(scripthash, scripthashes) == true {
+				continue
+			}
+			scripthashes = append(scripthashes, scripthash)
+		}
+	}
+	return scripthashes
+}
+
+// GetInputsAddresses returns the addresses of the resolved prevouts
+func (tx *Tx) GetInputsAddresses() []string {
+	addresses := []string{}
+	for _, vin := range tx.Vin {
+		if vin.PrevScriptpubkey == nil || len(vin.PrevScriptpubkey.Addresses) != 1 {
+			continue
+		}
+		addr := vin.PrevScriptpubkey.Addresses[0]
+		if checkExist(addr, addresses) == true {
+			continue
+		}
+		addresses = append(addresses, addr)
+	}
+	return addresses
+}
+
+// GetInputsScripthashes returns the script hashes of the resolved prevouts,
+// which are the scripts spending with tx
+func (tx *Tx) GetInputsScripthashes() []string {
+	scripthashes := []string{}
+	for _, vin := range tx.Vin {
+		for _, scripthash := range vin.PrevScriptpubkey.Scripthashes() {
+			if checkExist(scripthash, scripthashes) == true {
+				continue
+			}
+			scripthashes = append(scripthashes, scripthash)
+		}
+	}
+	return scripthashes
+}
@@ -0,0 +1,331 @@
+package btc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	// the coinbase tx of block 1
+	coinbaseTxid = "0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098"
+	// a segwit tx spending the block 1 coinbase and a p2wpkh output to a
+	// p2pkh, a bare 1-of-2 multisig, an OP_RETURN and a p2wpkh output
+	spendTxid = "eb1bccee431ec9a4f3a8dc8237fe234b5e855c990c5842eb19c1e497b5cb15a0"
+	spendHash = "ae3a5caf2136d430fca8800356e33740dc320a536dcd21ecab800fda1c995cec"
+)
+
+type wantVout struct {
+	value     int64
+	keytype   string
+	address   string
+	addresses []string
+	reqsigs   int
+}
+
+// loadTxFixture reads a tx written by getrawtransaction or REST together
+// with the raw tx in its hex field
+func loadTxFixture(t testing.TB, name string) (*Tx, []byte) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &Tx{}
+	err = json.Unmarshal(data, tx)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	raw := struct {
+		Hex string `json:"hex"`
+	}{}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := hex.DecodeString(raw.Hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx, b
+}
+
+func TestTxUnmarshal(t *testing.T) {
+	p2pk := "12c6DSiU4Rq3P4ZxziKxzrL5LmMBrzjrJX"
+	key1 := "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"
+	key2 := "1cMh228HTCiwS8ZsaakH8A8wze1JR5ZsP"
+	p2wpkh := "bc1qq6hag67dl53wl99vzg42z8eyzfz2xlkvxechjp"
+	tests := []struct {
+		file      string
+		txid      string
+		blockHash string
+		coinbase  bool
+		vouts     []wantVout
+	}{
+		{
+			// before 22.0 a p2pk output lists the address of its key
+			file:      "getrawtransaction_coinbase_v21.json",
+			txid:      coinbaseTxid,
+			blockHash: "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048",
+			coinbase:  true,
+			vouts:     []wantVout{{5000000000, "pubkey", p2pk, []string{p2pk}, 1}},
+		},
+		{
+			file:      "getrawtransaction_coinbase_v23.json",
+			txid:      coinbaseTxid,
+			blockHash: "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048",
+			coinbase:  true,
+			vouts:     []wantVout{{5000000000, "pubkey", "", []string{}, 0}},
+		},
+		{
+			file:     "rest_coinbase_v21.json",
+			txid:     coinbaseTxid,
+			coinbase: true,
+			vouts:    []wantVout{{5000000000, "pubkey", p2pk, []string{p2pk}, 1}},
+		},
+		{
+			file:     "rest_coinbase_v23.json",
+			txid:     coinbaseTxid,
+			coinbase: true,
+			vouts:    []wantVout{{5000000000, "pubkey", "", []string{}, 0}},
+		},
+		{
+			file: "getrawtransaction_spend_v21.json",
+			txid: spendTxid,
+			vouts: []wantVout{
+				{1000000000, "pubkeyhash", key1, []string{key1}, 1},
+				{100000, "multisig", "", []string{key1, key2}, 1},
+				{0, "nulldata", "", []string{}, 0},
+				{3999890000, "witness_v0_keyhash", p2wpkh, []string{p2wpkh}, 1},
+			},
+		},
+		{
+			// 22.0 dropped addresses and reqSigs, which are filled in from
+			// address and the multisig script
+			file: "getrawtransaction_spend_v23.json",
+			txid: spendTxid,
+			vouts: []wantVout{
+				{1000000000, "pubkeyhash", key1, []string{key1}, 1},
+				{100000, "multisig", "", []string{}, 1},
+				{0, "nulldata", "", []string{}, 0},
+				{3999890000, "witness_v0_keyhash", p2wpkh, []string{p2wpkh}, 1},
+			},
+		},
+	}
+	for _, test := range tests {
+		tx, _ := loadTxFixture(t, test.file)
+		if tx.Txid != test.txid {
+			t.Errorf("%s: txid %s, want %s", test.file, tx.Txid, test.txid)
+		}
+		if tx.BlockHash != test.blockHash {
+			t.Errorf("%s: block hash %q, want %q", test.file, tx.BlockHash, test.blockHash)
+		}
+		if tx.CheckCoinbase() != test.coinbase {
+			t.Errorf("%s: coinbase %v, want %v", test.file, tx.CheckCoinbase(), test.coinbase)
+		}
+		if len(tx.Vout) != len(test.vouts) {
+			t.Fatalf("%s: %d vouts, want %d", test.file, len(tx.Vout), len(test.vouts))
+		}
+		for i, want := range test.vouts {
+			vout := tx.Vout[i]
+			spk := vout.Scriptpubkey
+			got := wantVout{vout.Value, spk.Keytype, spk.Address, spk.Addresses, spk.Reqsigs}
+			if reflect.DeepEqual(got, want) == false {
+				t.Errorf("%s: vout %d is %+v, want %+v", test.file, i, got, want)
+			}
+			if spk.Scripthash() == "" {
+				t.Errorf("%s: vout %d has no script hash", test.file, i)
+			}
+		}
+	}
+}
+
+func TestTxUnmarshalInputs(t *testing.T) {
+	tx, _ := loadTxFixture(t, "getrawtransaction_coinbase_v23.json")
+	vin := tx.Vin[0]
+	if vin.IsCoinbase() == false || vin.Coinbase != "04ffff001d0104" || vin.Sequence != 0xffffffff {
+		t.Errorf("coinbase vin %+v", vin)
+	}
+	tx, _ = loadTxFixture(t, "getrawtransaction_spend_v23.json")
+	if tx.Hash != spendHash {
+		t.Errorf("wtxid %s, want %s", tx.Hash, spendHash)
+	}
+	if len(tx.Vin) != 2 {
+		t.Fatalf("%d vins, want 2", len(tx.Vin))
+	}
+	if tx.Vin[0].Txid != coinbaseTxid || tx.Vin[0].Vout != 0 || tx.Vin[0].IsCoinbase() == true {
+		t.Errorf("vin 0 %+v", tx.Vin[0])
+	}
+	if tx.Vin[0].ScriptSig == nil || len(tx.Vin[0].ScriptSig.Hex) != 2*72 || len(tx.Vin[0].Txinwitness) != 0 {
+		t.Errorf("vin 0 script %+v witness %v", tx.Vin[0].ScriptSig, tx.Vin[0].Txinwitness)
+	}
+	if len(tx.Vin[1].Txinwitness) != 2 || tx.Vin[1].Sequence != 0xfffffffd {
+		t.Errorf("vin 1 witness %v sequence %d", tx.Vin[1].Txinwitness, tx.Vin[1].Sequence)
+	}
+	for _, vin := range tx.Vin {
+		if vin.Value != nil {
+			t.Errorf("vin %s:%d has a value before the prevout is resolved", vin.Txid, vin.Vout)
+		}
+	}
+}
+
+// TestTxMatchesWire checks that the json of bitcoind and the raw tx decode
+// to the same tx. The raw decoder writes scripts like bitcoind 22+.
+func TestTxMatchesWire(t *testing.T) {
+	files := []string{
+		"getrawtransaction_coinbase_v21.json",
+		"getrawtransaction_coinbase_v23.json",
+		"rest_coinbase_v21.json",
+		"rest_coinbase_v23.json",
+		"getrawtransaction_spend_v21.json",
+		"getrawtransaction_spend_v23.json",
+	}
+	for _, file := range files {
+		tx, raw := loadTxFixture(t, file)
+		wire, err := DecodeTx(raw, MainNetParams)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if wire.Txid != tx.Txid || wire.Hash != tx.Hash || wire.Weight != tx.Weight || wire.Version != tx.Version || wire.Locktime != tx.Locktime {
+			t.Errorf("%s: wire tx %s %s weight %d, json tx %s %s weight %d", file, wire.Txid, wire.Hash, wire.Weight, tx.Txid, tx.Hash, tx.Weight)
+		}
+		for i, vin := range tx.Vin {
+			if reflect.DeepEqual(wire.Vin[i], vin) == false {
+				t.Errorf("%s: wire vin %d %+v %+v, json vin %+v %+v", file, i, wire.Vin[i], wire.Vin[i].ScriptSig, vin, vin.ScriptSig)
+			}
+		}
+		for i, vout := range tx.Vout {
+			spk := *vout.Scriptpubkey
+			wspk := *wire.Vout[i].Scriptpubkey
+			if wire.Vout[i].Value != vout.Value || wspk.Hex != spk.Hex || wspk.Asm != spk.Asm || wspk.Keytype != spk.Keytype {
+				t.Errorf("%s: wire vout %d %+v, json vout %+v", file, i, wspk, spk)
+			}
+			spk.Desc = ""
+			if strings.HasSuffix(file, "_v23.json") == true && reflect.DeepEqual(wspk, spk) == false {
+				t.Errorf("%s: wire script %d %+v, json script %+v", file, i, wspk, spk)
+			}
+		}
+	}
+}
+
+func TestTxCoinbaseMaturity(t *testing.T) {
+	tx, _ := loadTxFixture(t, "getrawtransaction_coinbase_v23.json")
+	tx.BlockHeight = 1
+	tests := []struct {
+		tip           int64
+		confirmations int64
+		mature        bool
+	}{
+		{0, 0, false},
+		{1, 1, false},
+		{99, 99, false},
+		{100, 100, true},
+	}
+	for _, test := range tests {
+		tx.UpdateConfirmations(test.tip)
+		if tx.IsCoinbase == false || tx.Confirmations != test.confirmations || tx.Mature != test.mature {
+			t.Errorf("tip %d: coinbase %v confirmations %d mature %v, want %d %v", test.tip, tx.IsCoinbase, tx.Confirmations, tx.Mature, test.confirmations, test.mature)
+		}
+	}
+	spend, _ := loadTxFixture(t, "getrawtransaction_spend_v23.json")
+	spend.UpdateConfirmations(1)
+	if spend.IsCoinbase == true || spend.Mature == false {
+		t.Errorf("spend coinbase %v mature %v", spend.IsCoinbase, spend.Mature)
+	}
+}
+
+func TestScriptPubkeyScripthash(t *testing.T) {
+	tx, _ := loadTxFixture(t, "getrawtransaction_spend_v21.json")
+	for _, vout := range tx.Vout {
+		spk := vout.Scriptpubkey
+		if spk.Address == "" {
+			continue
+		}
+		hash := ToScripthash(spk.Address)
+		if hash != spk.Scripthash() {
+			t.Errorf("%s: script hash %s, want %s", spk.Address, hash, spk.Scripthash())
+		}
+	}
+}
+
+func TestTxFee(t *testing.T) {
+	tx, _ := loadTxFixture(t, "getrawtransaction_spend_v23.json")
+	tx.AddFeeData()
+	if tx.Fee != nil || tx.Feerate != nil {
+		t.Fatalf("fee %v feerate %v before the prevouts are resolved", tx.Fee, tx.Feerate)
+	}
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"fee`) == true {
+		t.Errorf("unknown fee is written: %s", data)
+	}
+	values := []int64{5000000000, 10000}
+	for i, vin := range tx.Vin {
+		vin.Value = &values[i]
+	}
+	tx.AddFeeData()
+	if tx.Fee == nil || *tx.Fee != 20000 || tx.Feerate == nil || *tx.Feerate != 55.71 {
+		t.Fatalf("fee %v feerate %v", tx.Fee, tx.Feerate)
+	}
+	data, err = json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &Tx{}
+	err = json.Unmarshal(data, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Fee == nil || *res.Fee != 20000 || res.Feerate == nil || *res.Feerate != 55.71 {
+		t.Errorf("fee %v feerate %v after a round trip of %s", res.Fee, res.Feerate, data)
+	}
+
+	// getblock of bitcoind 0.21+ reports the fee in BTC
+	tx = &Tx{}
+	err = json.Unmarshal([]byte(`{"txid":"`+spendTxid+`","weight":1434,"vin":[{"txid":"`+coinbaseTxid+`","vout":0}],"fee":0.00020000}`), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.AddFeeData()
+	if tx.Fee == nil || *tx.Fee != 20000 || tx.Feerate == nil || *tx.Feerate != 55.71 {
+		t.Errorf("bitcoind fee %v feerate %v", tx.Fee, tx.Feerate)
+	}
+
+	// a fee of zero is known and written
+	zero := int64(0)
+	data, err = json.Marshal(&Tx{Txid: spendTxid, Fee: &zero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"fee":"0","feeSat":0`) == false {
+		t.Errorf("zero fee is not written: %s", data)
+	}
+}
+
+// TestTxConfirmsHeight checks that the deprecated confirms keeps the block
+// height, which clients read it as
+func TestTxConfirmsHeight(t *testing.T) {
+	tx, _ := loadTxFixture(t, "getrawtransaction_spend_v23.json")
+	tx.BlockHeight = 10
+	tx.UpdateConfirmations(12)
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := struct {
+		Confirms      int64 `json:"confirms"`
+		Confirmations int64 `json:"confirmations"`
+	}{}
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Confirms != 10 || res.Confirmations != 3 {
+		t.Errorf("confirms %d confirmations %d, want 10 and 3", res.Confirms, res.Confirmations)
+	}
+}
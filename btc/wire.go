@@ -0,0 +1,241 @@
+package btc
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+const maxWireSize = 32 * 1024 * 1024
+
+type wireReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *wireReader) read(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, errors.New("unexpected end of data")
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *wireReader) readUint32() (uint32, error) {
+	b, err := r.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *wireReader) readUint64() (uint64, error) {
+	b, err := r.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (r *wireReader) readVarInt() (uint64, error) {
+	b, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+	switch b[0] {
+	case 0xfd:
+		v, err := r.read(2)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.LittleEndian.Uint16(v)), nil
+	case 0xfe:
+		v, err := r.readUint32()
+		return uint64(v), err
+	case 0xff:
+		return r.readUint64()
+	}
+	return uint64(b[0]), nil
+}
+
+func (r *wireReader) readVarBytes() ([]byte, error) {
+	size, err := r.readVarInt()
+	if err != nil {
+		return nil, err
+	}
+	if size > maxWireSize {
+		return nil, errors.New("var bytes are too large")
+	}
+	return r.read(int(size))
+}
+
+// DecodeTx deserializes a tx in the bitcoin wire format (with or without
+// segwit witnesses) into the same fields bitcoind returns as json
+func DecodeTx(data []byte, params *Params) (*Tx, error) {
+	r := &wireReader{data: data}
+	tx, err := decodeTx(r, params)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, errors.New("tx has trailing data")
+	}
+	return tx, nil
+}
+
+func decodeTx(r *wireReader, params *Params) (*Tx, error) {
+	start := r.pos
+	version, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	// segwit marker and flag
+	witness := false
+	if r.pos+2 <= len(r.data) && r.data[r.pos] == 0x00 && r.data[r.pos+1] == 0x01 {
+		witness = true
+		r.pos += 2
+	}
+	base := []byte{}
+	base = append(base, r.data[start:start+4]...)
+	bodyStart := r.pos
+	inCount, err := r.readVarInt()
+	if err != nil {
+		return nil, err
+	}
+	if inCount > maxWireSize {
+		return nil, errors.New("too many inputs")
+	}
+	tx := &Tx{Version: int(version)}
+	for i := uint64(0); i < inCount; i++ {
+		prev, err := r.read(32)
+		if err != nil {
+			return nil, err
+		}
+		index, err := r.readUint32()
+		if err != nil {
+			return nil, err
+		}
+		script, err := r.readVarBytes()
+		if err != nil {
+			return nil, err
+		}
+		sequence, err := r.readUint32()
+		if err != nil {
+			return nil, err
+		}
+		vin := &Vin{Sequence: int64(sequence)}
+		if isNullHash(prev) == false || index != 0xffffffff {
+			vin.Txid = reverseHex(prev)
+			vin.Vout = int(index)
+			vin.ScriptSig = &ScriptSig{Asm: ScriptSigToAsm(script), Hex: hex.EncodeToString(script)}
+		} else {
+			vin.Coinbase = hex.EncodeToString(script)
+		}
+		tx.Vin = append(tx.Vin, vin)
+	}
+	outCount, err := r.readVarInt()
+	if err != nil {
+		return nil, err
+	}
+	if outCount > maxWireSize {
+		return nil, errors.New("too many outputs")
+	}
+	for i := uint64(0); i < outCount; i++ {
+		value, err := r.readUint64()
+		if err != nil {
+			return nil, err
+		}
+		script, err := r.readVarBytes()
+		if err != nil {
+			return nil, err
+		}
+		vout := &Vout{
+			Value:        int64(value),
+			Txs:          []string{},
+			N:            int(i),
+			Scriptpubkey: NewScriptPubkey(script, params),
+		}
+		tx.Vout = append(tx.Vout, vout)
+	}
+	base = append(base, r.data[bodyStart:r.pos]...)
+	if witness == true {
+		for i := uint64(0); i < inCount; i++ {
+			items, err := r.readVarInt()
+			if err != nil {
+				return nil, err
+			}
+			for j := uint64(0); j < items; j++ {
+				item, err := r.readVarBytes()
+				if err != nil {
+					return nil, err
+				}
+				tx.Vin[i].Txinwitness = append(tx.Vin[i].Txinwitness, hex.EncodeToString(item))
+			}
+		}
+	}
+	locktime, err := r.read(4)
+	if err != nil {
+		return nil, err
+	}
+	base = append(base, locktime...)
+	tx.Locktime = int(binary.LittleEndian.Uint32(locktime))
+	tx.Txid = reverseHex(doubleSha256(base))
+	tx.Hash = reverseHex(doubleSha256(r.data[start:r.pos]))
+	tx.Weight = len(base)*3 + (r.pos - start)
+	return tx, nil
+}
+
+// DecodeBlock deserializes a block in the bitcoin wire format. Height and
+// Mediantime are not part of the wire format and are left zero.
+func DecodeBlock(data []byte, params *Params) (*Block, error) {
+	r := &wireReader{data: data}
+	header, err := r.read(80)
+	if err != nil {
+		return nil, err
+	}
+	block := &Block{
+		Hash:              reverseHex(doubleSha256(header)),
+		Previousblockhash: reverseHex(header[4:36]),
+		Time:              int64(binary.LittleEndian.Uint32(header[68:72])),
+	}
+	if isNullHash(header[4:36]) == true {
+		block.Previousblockhash = ""
+	}
+	count, err := r.readVarInt()
+	if err != nil {
+		return nil, err
+	}
+	if count > maxWireSize {
+		return nil, errors.New("too many txs")
+	}
+	for i := uint64(0); i < count; i++ {
+		tx, err := decodeTx(r, params)
+		if err != nil {
+			return nil, err
+		}
+		block.Txs = append(block.Txs, tx)
+	}
+	if r.pos != len(data) {
+		return nil, errors.New("block has trailing data")
+	}
+	block.Ntx = int64(len(block.Txs))
+	return block, nil
+}
+
+func reverseHex(b []byte) string {
+	res := make([]byte, len(b))
+	for i := range b {
+		res[i] = b[len(b)-1-i]
+	}
+	return hex.EncodeToString(res)
+}
+
+func isNullHash(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
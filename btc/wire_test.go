@@ -0,0 +1,143 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+const (
+	genesisHeader = "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c"
+	genesisTx     = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+)
+
+func mustDecodeHex(t testing.TB, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeBlockGenesis(t *testing.T) {
+	data := mustDecodeHex(t, genesisHeader+"01"+genesisTx)
+	block, err := DecodeBlock(data, MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash != "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f" {
+		t.Errorf("hash %s", block.Hash)
+	}
+	if block.Previousblockhash != "" || block.Time != 1231006505 || block.Ntx != 1 {
+		t.Errorf("previous %q time %d nTx %d", block.Previousblockhash, block.Time, block.Ntx)
+	}
+	tx := block.Txs[0]
+	if tx.Txid != "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b" || tx.Hash != tx.Txid {
+		t.Errorf("txid %s wtxid %s", tx.Txid, tx.Hash)
+	}
+	if tx.Weight != 4*204 || tx.CheckCoinbase() == false {
+		t.Errorf("weight %d coinbase %v", tx.Weight, tx.CheckCoinbase())
+	}
+	if len(tx.Vout) != 1 || tx.Vout[0].Value != 50*satPerBTC || tx.Vout[0].Scriptpubkey.Keytype != "pubkey" {
+		t.Errorf("vout %+v", tx.Vout[0])
+	}
+}
+
+func TestDecodeBlockErrors(t *testing.T) {
+	data := mustDecodeHex(t, genesisHeader+"01"+genesisTx)
+	tests := map[string][]byte{
+		"short header":  data[:79],
+		"missing tx":    data[:81],
+		"truncated tx":  data[:len(data)-1],
+		"trailing data": append(append([]byte{}, data...), 0),
+		"too many txs":  append(mustDecodeHex(t, genesisHeader), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff),
+	}
+	for name, data := range tests {
+		_, err := DecodeBlock(data, MainNetParams)
+		if err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestDecodeTxSegwit(t *testing.T) {
+	_, raw := loadTxFixture(t, "getrawtransaction_spend_v23.json")
+	tx, err := DecodeTx(raw, MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the txid leaves out the witnesses, the wtxid covers them
+	if tx.Txid != spendTxid || tx.Hash != spendHash {
+		t.Errorf("txid %s wtxid %s", tx.Txid, tx.Hash)
+	}
+	// the marker, the flag and the 108 bytes of witnesses weigh 1 per byte
+	if tx.Weight != 1434 || len(raw) != 441 {
+		t.Errorf("weight %d size %d", tx.Weight, len(raw))
+	}
+	tx.AddFeeData()
+	if tx.Vsize != 359 {
+		t.Errorf("vsize %d", tx.Vsize)
+	}
+	if len(tx.Vin[0].Txinwitness) != 0 {
+		t.Errorf("vin 0 witness %v", tx.Vin[0].Txinwitness)
+	}
+	witness := tx.Vin[1].Txinwitness
+	if len(witness) != 2 || witness[1] != "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" {
+		t.Errorf("vin 1 witness %v", witness)
+	}
+	if tx.Vin[1].ScriptSig == nil || tx.Vin[1].ScriptSig.Hex != "" {
+		t.Errorf("vin 1 script %+v", tx.Vin[1].ScriptSig)
+	}
+	_, err = DecodeTx(raw[:len(raw)-4], MainNetParams)
+	if err == nil {
+		t.Error("truncated tx is decoded")
+	}
+}
+
+// benchBlock builds a block of the genesis coinbase and n copies of the
+// segwit fixture tx
+func benchBlock(b *testing.B, n int) []byte {
+	_, spend := loadTxFixture(b, "getrawtransaction_spend_v23.json")
+	buf := bytes.NewBuffer(mustDecodeHex(b, genesisHeader))
+	buf.Write([]byte{0xfd, byte((n + 1) & 0xff), byte((n + 1) >> 8)})
+	buf.Write(mustDecodeHex(b, genesisTx))
+	for i := 0; i < n; i++ {
+		buf.Write(spend)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkDecodeBlock(b *testing.B) {
+	data := benchBlock(b, 2000)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := DecodeBlock(data, MainNetParams)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUnmarshalBlockJSON decodes the block of BenchmarkDecodeBlock from
+// json as the REST and RPC sources do
+func BenchmarkUnmarshalBlockJSON(b *testing.B) {
+	block, err := DecodeBlock(benchBlock(b, 2000), MainNetParams)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data, err := json.Marshal(block)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res := Block{}
+		err := json.Unmarshal(data, &res)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
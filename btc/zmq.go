@@ -0,0 +1,108 @@
+package btc
+
+import (
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/SwingbyProtocol/tx-indexer/zmq"
+	log "github.com/sirupsen/logrus"
+)
+
+var zmqTopics = []string{"hashblock", "rawtx", "sequence"}
+
+// zmqMaxRawTxs bounds the rawtxs waiting for their sequence message, which
+// never comes when bitcoind does not publish sequence
+const zmqMaxRawTxs = 50000
+
+// StartZMQ subscribes to the zmq publisher of bitcoind (-zmqpubhashblock,
+// -zmqpubrawtx and -zmqpubsequence on the same address). Polling keeps
+// running as a fallback for missed notifications.
+func (b *BlockChain) StartZMQ(addr string) {
+	go b.doSubscribeZMQ(addr)
+}
+
+func (b *BlockChain) doSubscribeZMQ(addr string) {
+	for {
+		err := b.subscribeZMQ(addr)
+		log.Info("zmq: ", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (b *BlockChain) subscribeZMQ(addr string) error {
+	info, err := b.source.GetChainInfo()
+	if err != nil {
+		return err
+	}
+	params := GetParams(info.Chain)
+	sub, err := zmq.Subscribe(addr, zmqTopics)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	log.Info("zmq subscribed -> ", addr)
+	// rawtx is also published for the txs of connected blocks, so a tx is
+	// only added when the sequence message for it reports a mempool accept.
+	// rawtx is published before the sequence message of the same tx.
+	rawtxs := make(map[string][]byte)
+	for {
+		msg, err := sub.Recv()
+		if err != nil {
+			return err
+		}
+		if len(msg) < 2 {
+			continue
+		}
+		switch string(msg[0]) {
+		case "hashblock":
+			b.Notify()
+		case "rawtx":
+			tx, err := DecodeTx(msg[1], params)
+			if err != nil {
+				log.Info(err)
+				continue
+			}
+			if len(rawtxs) >= zmqMaxRawTxs {
+				log.Warn("zmq: no sequence messages for the buffered rawtxs, is -zmqpubsequence set?")
+				rawtxs = make(map[string][]byte)
+			}
+			rawtxs[tx.Txid] = msg[1]
+		case "sequence":
+			err := b.handleSequence(msg[1], rawtxs, params)
+			if err != nil {
+				log.Info(err)
+			}
+		}
+	}
+}
+
+// handleSequence handles <32-byte hash><1-byte label> messages. A mempool
+// accept adds the tx received with rawtx, and the rawtxs of a connected
+// block are dropped as the block sync indexes them.
+func (b *BlockChain) handleSequence(body []byte, rawtxs map[string][]byte, params *Params) error {
+	if len(body) < 33 {
+		return errors.New("zmq sequence message is too short")
+	}
+	hash := hex.EncodeToString(body[:32])
+	switch body[32] {
+	case 'A':
+		data, ok := rawtxs[hash]
+		if ok == false {
+			return nil
+		}
+		delete(rawtxs, hash)
+		return b.mempool.AddRawTx(data, params)
+	case 'C':
+		for txid := range rawtxs {
+			delete(rawtxs, txid)
+		}
+		b.Notify()
+	case 'D':
+		b.Notify()
+	case 'R':
+		delete(rawtxs, hash)
+		b.mempool.RemoveTx(hash)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package btc
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/SwingbyProtocol/tx-indexer/zmq"
+)
+
+// zmqSequence is the body of a sequence message of bitcoind
+func zmqSequence(t *testing.T, hash string, label byte) []byte {
+	return append(mustDecodeHex(t, hash), label)
+}
+
+func publishZMQ(pub *zmq.Publisher, topic string, body []byte, seq uint32) {
+	num := make([]byte, 4)
+	binary.LittleEndian.PutUint32(num, seq)
+	pub.Publish([]byte(topic), body, num)
+}
+
+// TestZMQSequence publishes rawtx and sequence messages like bitcoind and
+// checks that only txs accepted to the mempool are added
+func TestZMQSequence(t *testing.T) {
+	pub, err := zmq.Listen("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	b := NewBlockchain(&Config{Source: &fakeSource{info: ChainInfo{Chain: "main"}}})
+	b.StartZMQ(pub.Addr())
+
+	// the subscriptions are sent after the handshake, so publish until the
+	// first hashblock arrives
+	deadline := time.After(5 * time.Second)
+	subscribed := false
+	for subscribed == false {
+		publishZMQ(pub, "hashblock", mustDecodeHex(t, genesisHash), 0)
+		select {
+		case <-b.notifychan:
+			subscribed = true
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("zmq subscriber did not connect")
+		}
+	}
+	recvTx := func(want string) {
+		select {
+		case tx := <-b.mempool.waitchan:
+			if tx.Txid != want {
+				t.Fatalf("added tx %s, want %s", tx.Txid, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("tx %s is not added", want)
+		}
+	}
+	recvNotify := func(what string) {
+		select {
+		case <-b.notifychan:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no block sync after %s", what)
+		}
+	}
+
+	_, spend := loadTxFixture(t, "getrawtransaction_spend_v23.json")
+	_, coinbase := loadTxFixture(t, "getrawtransaction_coinbase_v23.json")
+	genesisTxid := "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+	// a mempool accept adds the tx
+	publishZMQ(pub, "rawtx", spend, 1)
+	publishZMQ(pub, "sequence", zmqSequence(t, spendTxid, 'A'), 2)
+	recvTx(spendTxid)
+	// the txs of a connected block are not added
+	publishZMQ(pub, "rawtx", mustDecodeHex(t, genesisTx), 3)
+	publishZMQ(pub, "sequence", zmqSequence(t, genesisHash, 'C'), 4)
+	recvNotify("a connected block")
+	publishZMQ(pub, "sequence", zmqSequence(t, genesisTxid, 'A'), 5)
+	publishZMQ(pub, "rawtx", coinbase, 6)
+	publishZMQ(pub, "sequence", zmqSequence(t, coinbaseTxid, 'A'), 7)
+	recvTx(coinbaseTxid)
+	// a mempool removal removes the tx
+	publishZMQ(pub, "sequence", zmqSequence(t, spendTxid, 'R'), 8)
+	select {
+	case txid := <-b.mempool.removechan:
+		if txid != spendTxid {
+			t.Errorf("removed tx %s, want %s", txid, spendTxid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tx is not removed")
+	}
+	if b.mempool.HasTx(spendTxid) == true || b.mempool.HasTx(coinbaseTxid) == false {
+		t.Error("mempool does not follow the sequence")
+	}
+	publishZMQ(pub, "sequence", zmqSequence(t, genesisHash, 'D'), 9)
+	recvNotify("a disconnected block")
+}
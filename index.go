@@ -4,8 +4,12 @@ import (
 	"flag"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/SwingbyProtocol/tx-indexer/btc"
+	"github.com/SwingbyProtocol/tx-indexer/resolver"
 	"github.com/ant0ine/go-json-rest/rest"
 	log "github.com/sirupsen/logrus"
 )
@@ -20,17 +24,104 @@ func init() {
 }
 
 func main() {
-	bitcoind := flag.String("bitcoind", "http://localhost:8332", "bitcoind endpoint")
+	bitcoind := flag.String("bitcoind", "http://localhost:8332", "bitcoind endpoints, or esplora endpoints for the esplora transport (comma separated)")
+	binary := flag.Bool("binary", false, "load blocks and txs from the binary rest endpoints")
+	crosscheck := flag.Bool("crosscheck", false, "accept blocks only when all healthy bitcoind endpoints agree")
+	transport := flag.String("transport", "rest", "bitcoind transport (rest, rpc, p2p or esplora)")
+	rpcUser := flag.String("rpcuser", "", "bitcoind rpc user")
+	rpcPassword := flag.String("rpcpassword", "", "bitcoind rpc password")
+	rpcCookie := flag.String("rpccookie", "", "bitcoind rpc cookie file")
+	peer := flag.String("peer", "", "bitcoind p2p address for the p2p transport (default localhost and the port of -chain)")
+	chain := flag.String("chain", "main", "chain of the p2p or esplora transport (main, test, testnet4, signet or regtest)")
+	record := flag.String("record", "", "record bitcoind or esplora responses to fixture files in this directory")
+	replay := flag.String("replay", "", "serve bitcoind or esplora responses from the fixture files in this directory")
 	bind := flag.String("bind", "0.0.0.0:9096", "")
 	prune := flag.Int("prune", 4, "prune blocks")
 	wsBind := flag.String("wsbind", "0.0.0.0:9099", "websocket bind")
+	zmqAddr := flag.String("zmq", "", "bitcoind zmq publisher for hashblock, rawtx and sequence (e.g. tcp://127.0.0.1:28332)")
+	datadir := flag.String("datadir", "./data", "checkpoint directory (empty to disable)")
+	storageType := flag.String("storage", "memory", "tx storage backend (memory or bolt)")
+	startHeight := flag.Int64("start-height", 0, "backfill blocks from this height on first start")
+	backfillWorkers := flag.Int("backfill-workers", 4, "concurrent block fetches while catching up")
+	prevoutLookup := flag.Bool("prevout-lookup", false, "load prevouts which are not indexed from bitcoind to compute fees and index senders (needs -txindex)")
+	mempoolWorkers := flag.Int("mempool-workers", 8, "concurrent mempool tx fetches")
 	flag.Parse()
 
-	log.Println("bitcoind ->", *bitcoind, "bind ->", *bind, "prune ->", *prune, "websocket bind ->", *wsBind+"/ws")
+	log.Println("bitcoind ->", *bitcoind, "transport ->", *transport, "bind ->", *bind, "prune ->", *prune, "websocket bind ->", *wsBind+"/ws", "datadir ->", *datadir, "storage ->", *storageType, "start height ->", *startHeight)
 
 	api := rest.NewApi()
 	api.Use(rest.DefaultDevStack...)
-	btcNode := btc.NewNode(*bitcoind, *prune)
+	uris := strings.Split(*bitcoind, ",")
+	var source btc.Source
+	switch *transport {
+	case "rest":
+		rest := btc.NewRESTSource(uris, *crosscheck)
+		if *binary {
+			rest.EnableBinary()
+		}
+		if len(uris) > 1 {
+			rest.StartHealthCheck(10 * time.Second)
+		}
+		setFixtures(rest.Resolver(), *record, *replay)
+		source = rest
+	case "rpc":
+		if len(uris) > 1 {
+			log.Warn("rpc transport uses the first bitcoind endpoint only")
+		}
+		client := resolver.NewRPCClient(uris[0], *rpcUser, *rpcPassword)
+		if *rpcCookie != "" {
+			client = resolver.NewRPCClientWithCookie(uris[0], *rpcCookie)
+		}
+		source = btc.NewRPCSource(client)
+	case "p2p":
+		params := getParams(*chain)
+		addr := *peer
+		if addr == "" {
+			addr = "localhost:" + params.DefaultPort
+		}
+		source = btc.NewP2PSource(addr, params)
+	case "esplora":
+		esplora := btc.NewEsploraSource(uris, getParams(*chain))
+		if len(uris) > 1 {
+			esplora.StartHealthCheck(10 * time.Second)
+		}
+		setFixtures(esplora.Resolver(), *record, *replay)
+		source = esplora
+	default:
+		log.Fatal("unknown transport: ", *transport)
+	}
+
+	var storage btc.Storage
+	switch *storageType {
+	case "memory":
+		storage = btc.NewMemStorage()
+	case "bolt":
+		if *datadir == "" {
+			log.Fatal("bolt storage requires -datadir")
+		}
+		err := os.MkdirAll(*datadir, 0755)
+		if err != nil {
+			log.Fatal(err)
+		}
+		storage, err = btc.NewBoltStorage(filepath.Join(*datadir, "storage.db"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("unknown storage backend: ", *storageType)
+	}
+	defer storage.Close()
+	btcNode := btc.NewNode(&btc.Config{
+		Source:          source,
+		PruneBlocks:     *prune,
+		DataDir:         *datadir,
+		Storage:         storage,
+		StartHeight:     *startHeight,
+		BackfillWorkers: *backfillWorkers,
+		MempoolWorkers:  *mempoolWorkers,
+		PrevoutLookup:   *prevoutLookup,
+		ZMQAddr:         *zmqAddr,
+	})
 	btcNode.Start()
 	router, err := rest.MakeRouter(
 		rest.Get("/keep", func(w rest.ResponseWriter, r *rest.Request) {
@@ -38,6 +129,9 @@ func main() {
 			w.WriteJson([]string{})
 		}),
 		rest.Get("/txs/btc/:address", btcNode.GetTxs),
+		rest.Get("/fees/btc", btcNode.GetFees),
+		rest.Get("/address/btc/:address/balance", btcNode.GetBalance),
+		rest.Get("/address/btc/:address/utxos", btcNode.GetUtxos),
 		//rest.Get("/txs/btc/tx/:txid", btcNode.GetTx),
 		//rest.Get("/txs/btc/index/:address", btcNode.GetIndex),
 	)
@@ -60,3 +154,23 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+func getParams(chain string) *btc.Params {
+	params := btc.GetParams(chain)
+	if params.Name != chain {
+		log.Fatal("unknown chain: ", chain)
+	}
+	return params
+}
+
+func setFixtures(r *resolver.Resolver, record string, replay string) {
+	if record != "" {
+		err := r.Record(record)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if replay != "" {
+		r.Replay(replay)
+	}
+}
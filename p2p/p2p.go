@@ -0,0 +1,369 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProtocolVersion = 70016
+	UserAgent       = "/tx-indexer:0.1/"
+	maxPayload      = 32 * 1024 * 1024
+	maxHeaders      = 2000
+	// bitcoind pings every two minutes, a silent peer is gone
+	idleTimeout = 5 * time.Minute
+)
+
+const (
+	InvTypeTx           = 1
+	InvTypeBlock        = 2
+	InvTypeWitnessTx    = 0x40000001
+	InvTypeWitnessBlock = 0x40000002
+)
+
+// Peer is a connection to a bitcoin node over the P2P wire protocol
+type Peer struct {
+	conn        net.Conn
+	magic       uint32
+	mu          sync.Mutex
+	Version     int32
+	UserAgent   string
+	StartHeight int32
+}
+
+type InvVect struct {
+	Type uint32
+	Hash [32]byte
+}
+
+// Connect dials the node and completes the version handshake
+func Connect(addr string, magic uint32, timeout time.Duration) (*Peer, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return Handshake(conn, magic, timeout)
+}
+
+// Handshake completes the version handshake on a connection opened to the
+// node. The connection is closed when it fails.
+func Handshake(conn net.Conn, magic uint32, timeout time.Duration) (*Peer, error) {
+	p := &Peer{conn: conn, magic: magic}
+	err := p.handshake(timeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Accept completes the version handshake on a connection opened by the
+// remote node, which sends its version first
+func Accept(conn net.Conn, magic uint32, timeout time.Duration) (*Peer, error) {
+	p := &Peer{conn: conn, magic: magic}
+	err := p.accept(timeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Peer) handshake(timeout time.Duration) error {
+	p.conn.SetDeadline(time.Now().Add(timeout))
+	defer p.conn.SetDeadline(time.Time{})
+	err := p.WriteMessage("version", p.versionPayload())
+	if err != nil {
+		return err
+	}
+	gotVersion := false
+	gotVerack := false
+	for gotVersion == false || gotVerack == false {
+		cmd, payload, err := p.readMessage()
+		if err != nil {
+			return err
+		}
+		switch cmd {
+		case "version":
+			err := p.parseVersion(payload)
+			if err != nil {
+				return err
+			}
+			gotVersion = true
+			err = p.WriteMessage("verack", nil)
+			if err != nil {
+				return err
+			}
+		case "verack":
+			gotVerack = true
+		}
+	}
+	return nil
+}
+
+func (p *Peer) accept(timeout time.Duration) error {
+	p.conn.SetDeadline(time.Now().Add(timeout))
+	defer p.conn.SetDeadline(time.Time{})
+	cmd, payload, err := p.readMessage()
+	if err != nil {
+		return err
+	}
+	if cmd != "version" {
+		return errors.New("p2p peer did not send its version first: " + cmd)
+	}
+	err = p.parseVersion(payload)
+	if err != nil {
+		return err
+	}
+	err = p.WriteMessage("version", p.versionPayload())
+	if err != nil {
+		return err
+	}
+	cmd, _, err = p.readMessage()
+	if err != nil {
+		return err
+	}
+	if cmd != "verack" {
+		return errors.New("p2p peer did not send verack: " + cmd)
+	}
+	return p.WriteMessage("verack", nil)
+}
+
+func (p *Peer) versionPayload() []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, int32(ProtocolVersion))
+	binary.Write(buf, binary.LittleEndian, uint64(0))
+	binary.Write(buf, binary.LittleEndian, time.Now().Unix())
+	// addr_recv and addr_from are not used by the remote node
+	buf.Write(make([]byte, 26))
+	buf.Write(make([]byte, 26))
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	buf.Write(nonce)
+	writeVarInt(buf, uint64(len(UserAgent)))
+	buf.WriteString(UserAgent)
+	binary.Write(buf, binary.LittleEndian, int32(0))
+	// relay txs
+	buf.WriteByte(1)
+	return buf.Bytes()
+}
+
+func (p *Peer) parseVersion(payload []byte) error {
+	r := bytes.NewReader(payload)
+	err := binary.Read(r, binary.LittleEndian, &p.Version)
+	if err != nil {
+		return err
+	}
+	// services, timestamp, addr_recv, addr_from, nonce
+	_, err = r.Seek(8+8+26+26+8, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	size, err := readVarInt(r)
+	if err != nil {
+		return err
+	}
+	if size > 256 {
+		return errors.New("user agent is too long")
+	}
+	agent := make([]byte, size)
+	_, err = io.ReadFull(r, agent)
+	if err != nil {
+		return err
+	}
+	p.UserAgent = string(agent)
+	return binary.Read(r, binary.LittleEndian, &p.StartHeight)
+}
+
+func (p *Peer) WriteMessage(command string, payload []byte) error {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], p.magic)
+	copy(header[4:16], command)
+	binary.LittleEndian.PutUint32(header[16:20], uint32(len(payload)))
+	sum := checksum(payload)
+	copy(header[20:24], sum[:4])
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.conn.Write(append(header, payload...))
+	return err
+}
+
+// ReadMessage returns the next message. Pings are answered automatically.
+func (p *Peer) ReadMessage() (string, []byte, error) {
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		cmd, payload, err := p.readMessage()
+		if err != nil {
+			return "", nil, err
+		}
+		if cmd == "ping" {
+			err := p.WriteMessage("pong", payload)
+			if err != nil {
+				return "", nil, err
+			}
+			continue
+		}
+		return cmd, payload, nil
+	}
+}
+
+func (p *Peer) readMessage() (string, []byte, error) {
+	header := make([]byte, 24)
+	_, err := io.ReadFull(p.conn, header)
+	if err != nil {
+		return "", nil, err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != p.magic {
+		return "", nil, errors.New("p2p magic mismatch")
+	}
+	command := strings.TrimRight(string(header[4:16]), "\x00")
+	size := binary.LittleEndian.Uint32(header[16:20])
+	if size > maxPayload {
+		return "", nil, errors.New("p2p payload is too large")
+	}
+	payload := make([]byte, size)
+	_, err = io.ReadFull(p.conn, payload)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := checksum(payload)
+	if bytes.Equal(sum[:4], header[20:24]) == false {
+		return "", nil, errors.New("p2p checksum mismatch " + command)
+	}
+	return command, payload, nil
+}
+
+func (p *Peer) Close() error {
+	return p.conn.Close()
+}
+
+func EncodeInv(invs []InvVect) []byte {
+	buf := &bytes.Buffer{}
+	writeVarInt(buf, uint64(len(invs)))
+	for _, inv := range invs {
+		binary.Write(buf, binary.LittleEndian, inv.Type)
+		buf.Write(inv.Hash[:])
+	}
+	return buf.Bytes()
+}
+
+func DecodeInv(payload []byte) ([]InvVect, error) {
+	r := bytes.NewReader(payload)
+	count, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if count*36 > uint64(len(payload)) {
+		return nil, errors.New("inv count is invalid")
+	}
+	invs := []InvVect{}
+	for i := uint64(0); i < count; i++ {
+		inv := InvVect{}
+		err := binary.Read(r, binary.LittleEndian, &inv.Type)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.ReadFull(r, inv.Hash[:])
+		if err != nil {
+			return nil, err
+		}
+		invs = append(invs, inv)
+	}
+	return invs, nil
+}
+
+// EncodeGetHeaders requests the headers following the first locator hash
+// known by the remote node
+func EncodeGetHeaders(locator [][32]byte, stop [32]byte) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, uint32(ProtocolVersion))
+	writeVarInt(buf, uint64(len(locator)))
+	for _, hash := range locator {
+		buf.Write(hash[:])
+	}
+	buf.Write(stop[:])
+	return buf.Bytes()
+}
+
+// DecodeHeaders returns the raw 80 byte headers of a headers message
+func DecodeHeaders(payload []byte) ([][]byte, error) {
+	r := bytes.NewReader(payload)
+	count, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if count > maxHeaders {
+		return nil, errors.New("too many headers")
+	}
+	headers := [][]byte{}
+	for i := uint64(0); i < count; i++ {
+		header := make([]byte, 80)
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			return nil, err
+		}
+		// tx count, always zero
+		_, err = readVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+// BlockHash returns the hash of a block header in wire byte order
+func BlockHash(header []byte) [32]byte {
+	return checksum(header)
+}
+
+func checksum(data []byte) [32]byte {
+	first := sha256.Sum256(data)
+	return sha256.Sum256(first[:])
+}
+
+func writeVarInt(buf *bytes.Buffer, v uint64) {
+	switch {
+	case v < 0xfd:
+		buf.WriteByte(byte(v))
+	case v <= 0xffff:
+		buf.WriteByte(0xfd)
+		binary.Write(buf, binary.LittleEndian, uint16(v))
+	case v <= 0xffffffff:
+		buf.WriteByte(0xfe)
+		binary.Write(buf, binary.LittleEndian, uint32(v))
+	default:
+		buf.WriteByte(0xff)
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+}
+
+func readVarInt(r *bytes.Reader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch b {
+	case 0xfd:
+		v := uint16(0)
+		err := binary.Read(r, binary.LittleEndian, &v)
+		return uint64(v), err
+	case 0xfe:
+		v := uint32(0)
+		err := binary.Read(r, binary.LittleEndian, &v)
+		return uint64(v), err
+	case 0xff:
+		v := uint64(0)
+		err := binary.Read(r, binary.LittleEndian, &v)
+		return v, err
+	}
+	return uint64(b), nil
+}
@@ -0,0 +1,124 @@
+package p2p
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testMagic = 0xdab5bffa
+
+// pipePeers connects a local and a remote peer over net.Pipe
+func pipePeers(t *testing.T) (*Peer, *Peer) {
+	local, remote := net.Pipe()
+	accepted := make(chan *Peer, 1)
+	go func() {
+		peer, err := Accept(remote, testMagic, time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- peer
+	}()
+	peer, err := Handshake(local, testMagic, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := <-accepted
+	if node == nil {
+		t.FailNow()
+	}
+	return peer, node
+}
+
+func TestHandshake(t *testing.T) {
+	peer, node := pipePeers(t)
+	defer peer.Close()
+	defer node.Close()
+	if peer.Version != ProtocolVersion || peer.UserAgent != UserAgent || node.UserAgent != UserAgent {
+		t.Errorf("version %d agent %q, remote agent %q", peer.Version, peer.UserAgent, node.UserAgent)
+	}
+
+	// a peer on another network is rejected
+	local, remote := net.Pipe()
+	go Accept(remote, testMagic+1, time.Second)
+	_, err := Handshake(local, testMagic, time.Second)
+	if err == nil {
+		t.Error("handshake with another network")
+	}
+}
+
+func TestInvGetData(t *testing.T) {
+	peer, node := pipePeers(t)
+	defer peer.Close()
+	defer node.Close()
+	invs := []InvVect{{Type: InvTypeTx, Hash: [32]byte{1}}, {Type: InvTypeBlock, Hash: [32]byte{2}}}
+	errs := make(chan error, 1)
+	go func() {
+		// a ping is answered by ReadMessage and not returned
+		err := node.WriteMessage("ping", []byte{1, 2, 3, 4, 5, 6, 7, 8})
+		if err != nil {
+			errs <- err
+			return
+		}
+		cmd, payload, err := node.ReadMessage()
+		if err == nil && (cmd != "pong" || len(payload) != 8 || payload[0] != 1) {
+			err = errors.New("pong " + cmd)
+		}
+		if err == nil {
+			err = node.WriteMessage("inv", EncodeInv(invs))
+		}
+		errs <- err
+	}()
+	cmd, payload, err := peer.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != "inv" {
+		t.Fatalf("command %s, want inv", cmd)
+	}
+	res, err := DecodeInv(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(res, invs) == false {
+		t.Errorf("inv %+v, want %+v", res, invs)
+	}
+	err = <-errs
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// getdata carries the requested invs
+	go func() {
+		errs <- peer.WriteMessage("getdata", EncodeInv(res[:1]))
+	}()
+	cmd, payload, err = node.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = DecodeInv(payload)
+	if err != nil || cmd != "getdata" || len(res) != 1 || res[0] != invs[0] {
+		t.Errorf("getdata %s %+v %v", cmd, res, err)
+	}
+	err = <-errs
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeInvErrors(t *testing.T) {
+	payloads := [][]byte{
+		{},
+		// two entries without their data
+		{2, 1, 0, 0, 0},
+		append([]byte{1, 1, 0, 0, 0}, make([]byte, 31)...),
+	}
+	for _, payload := range payloads {
+		_, err := DecodeInv(payload)
+		if err == nil {
+			t.Errorf("inv %x is decoded", payload)
+		}
+	}
+}
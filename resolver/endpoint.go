@@ -0,0 +1,125 @@
+package resolver
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxTipLag is the number of blocks an endpoint may fall behind the best
+// known tip before it is treated as stalled
+const MaxTipLag = 2
+
+type Endpoint struct {
+	URI      string
+	Healthy  bool
+	Latency  time.Duration
+	Failures int
+	Tip      int64
+}
+
+// Probe returns the tip height reported by a single endpoint
+type Probe func(e *Endpoint) (int64, error)
+
+func (r *Resolver) Endpoints() []*Endpoint {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := []*Endpoint{}
+	for _, e := range r.endpoints {
+		copied := *e
+		list = append(list, &copied)
+	}
+	return list
+}
+
+// preferred returns the endpoints ordered by health, failures and latency
+func (r *Resolver) preferred() []*Endpoint {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]*Endpoint, len(r.endpoints))
+	copy(list, r.endpoints)
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Healthy != list[j].Healthy {
+			return list[i].Healthy
+		}
+		if list[i].Failures != list[j].Failures {
+			return list[i].Failures < list[j].Failures
+		}
+		return list[i].Latency < list[j].Latency
+	})
+	return list
+}
+
+func (r *Resolver) markSuccess(e *Endpoint, latency time.Duration) {
+	r.mu.Lock()
+	if e.Latency == 0 {
+		e.Latency = latency
+	} else {
+		e.Latency = (e.Latency*4 + latency) / 5
+	}
+	e.Failures = 0
+	// an endpoint which lags behind stays unhealthy until it catches up
+	if e.Healthy == false && r.best-e.Tip <= MaxTipLag {
+		e.Healthy = true
+		log.Infof("endpoint %s is healthy again", e.URI)
+	}
+	r.mu.Unlock()
+}
+
+func (r *Resolver) markFailure(e *Endpoint, err error) {
+	r.mu.Lock()
+	e.Failures++
+	if e.Failures >= 3 && e.Healthy {
+		e.Healthy = false
+		log.Warnf("endpoint %s is unhealthy: %s", e.URI, err)
+	}
+	r.mu.Unlock()
+}
+
+// StartHealthCheck probes every endpoint periodically. An endpoint is healthy
+// when the probe succeeds and its tip is not behind the others by more than
+// MaxTipLag blocks.
+func (r *Resolver) StartHealthCheck(t time.Duration, probe Probe) {
+	go func() {
+		for {
+			r.checkHealth(probe)
+			time.Sleep(t)
+		}
+	}()
+}
+
+func (r *Resolver) checkHealth(probe Probe) {
+	r.mu.RLock()
+	endpoints := make([]*Endpoint, len(r.endpoints))
+	copy(endpoints, r.endpoints)
+	r.mu.RUnlock()
+	tips := make([]int64, len(endpoints))
+	errs := make([]error, len(endpoints))
+	best := int64(0)
+	for i, e := range endpoints {
+		start := time.Now()
+		tips[i], errs[i] = probe(e)
+		if errs[i] != nil {
+			r.markFailure(e, errs[i])
+			continue
+		}
+		r.markSuccess(e, time.Since(start))
+		if tips[i] > best {
+			best = tips[i]
+		}
+	}
+	r.mu.Lock()
+	r.best = best
+	for i, e := range endpoints {
+		healthy := errs[i] == nil && best-tips[i] <= MaxTipLag
+		if errs[i] == nil {
+			e.Tip = tips[i]
+		}
+		if healthy != e.Healthy {
+			log.Infof("endpoint %s healthy -> %t tip -> %d best -> %d", e.URI, healthy, e.Tip, best)
+		}
+		e.Healthy = healthy
+	}
+	r.mu.Unlock()
+}
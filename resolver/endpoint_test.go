@@ -0,0 +1,65 @@
+package resolver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEndpointHealth(t *testing.T) {
+	failing := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if failing == true {
+			w.WriteHeader(500)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	r := NewResolver(srv.URL)
+	res := struct{}{}
+	for i := 0; i < 3; i++ {
+		err := r.GetRequest("/rest/chaininfo.json", &res)
+		if err == nil {
+			t.Fatal("request to a failing endpoint")
+		}
+	}
+	if r.Endpoints()[0].Healthy == true {
+		t.Fatal("endpoint is healthy after 3 failures")
+	}
+	// a successful request restores the endpoint
+	failing = false
+	err := r.GetRequest("/rest/chaininfo.json", &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := r.Endpoints()[0]
+	if e.Healthy == false || e.Failures != 0 {
+		t.Errorf("endpoint after a success %+v", e)
+	}
+}
+
+func TestEndpointLagging(t *testing.T) {
+	r := NewMultiResolver([]string{"http://a", "http://b"})
+	tips := map[string]int64{"http://a": 10, "http://b": 10 - MaxTipLag - 1}
+	r.checkHealth(func(e *Endpoint) (int64, error) {
+		return tips[e.URI], nil
+	})
+	endpoints := r.Endpoints()
+	if endpoints[0].Healthy == false || endpoints[1].Healthy == true {
+		t.Fatalf("endpoints %+v %+v", endpoints[0], endpoints[1])
+	}
+	// a lagging endpoint stays unhealthy on success until it catches up
+	r.markSuccess(r.endpoints[1], time.Millisecond)
+	if r.Endpoints()[1].Healthy == true {
+		t.Error("lagging endpoint is healthy after a success")
+	}
+	tips["http://b"] = 10
+	r.checkHealth(func(e *Endpoint) (int64, error) {
+		return tips[e.URI], nil
+	})
+	if r.Endpoints()[1].Healthy == false {
+		t.Error("endpoint is unhealthy after catching up")
+	}
+}
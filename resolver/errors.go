@@ -0,0 +1,72 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+)
+
+var (
+	// ErrNotFound matches every error caused by a missing resource
+	ErrNotFound = errors.New("not found")
+	// ErrTimeout matches every error caused by a request deadline
+	ErrTimeout = errors.New("timeout")
+)
+
+// StatusError is returned when the endpoint replies with a non 200 status
+type StatusError struct {
+	Code  int
+	Query string
+}
+
+func (e *StatusError) Error() string {
+	return " -> " + strconv.Itoa(e.Code) + " " + e.Query
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrNotFound && e.Code == 404
+}
+
+// DecodeError is returned when the response body is not valid json
+type DecodeError struct {
+	Query string
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return "decode error " + e.Query + ": " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned when the request exceeds its deadline
+type TimeoutError struct {
+	Query string
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	return "timeout " + e.Query + ": " + e.Err.Error()
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func wrapRequestError(query string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &TimeoutError{query, err}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &TimeoutError{query, err}
+	}
+	return err
+}
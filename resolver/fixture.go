@@ -0,0 +1,79 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// fixture is a recorded response. Json bodies are kept readable so fixtures
+// can be edited by hand, other bodies are stored as base64.
+type fixture struct {
+	Query  string          `json:"query"`
+	Status int             `json:"status"`
+	JSON   json.RawMessage `json:"json,omitempty"`
+	Raw    []byte          `json:"raw,omitempty"`
+}
+
+// Record saves every response to a fixture file in dir
+func (r *Resolver) Record(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.record = dir
+	r.mu.Unlock()
+	return nil
+}
+
+// Replay serves responses from the fixture files in dir instead of the
+// endpoints. Queries which have not been recorded fail with ErrNotFound.
+func (r *Resolver) Replay(dir string) {
+	r.mu.Lock()
+	r.replay = dir
+	r.mu.Unlock()
+}
+
+func fixturePath(dir string, query string) string {
+	return filepath.Join(dir, url.QueryEscape(query)+".json")
+}
+
+func saveFixture(dir string, query string, status int, body []byte) error {
+	f := fixture{Query: query, Status: status}
+	if json.Valid(body) == true {
+		f.JSON = body
+	} else {
+		f.Raw = body
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fixturePath(dir, query), data, 0644)
+}
+
+func loadFixture(dir string, query string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fixturePath(dir, query))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no fixture for %s: %w", query, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := fixture{}
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, &DecodeError{query, err}
+	}
+	if f.Status != 200 {
+		return nil, &StatusError{f.Status, query}
+	}
+	if f.JSON != nil {
+		return f.JSON, nil
+	}
+	return f.Raw, nil
+}
@@ -0,0 +1,85 @@
+package resolver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bodies := map[string][]byte{
+		"/rest/chaininfo.json":    []byte(`{"chain":"regtest","blocks":1}`),
+		"/rest/tx/00.bin":         {0x02, 0x00, 0xff},
+		"/rest/tx/01.hex?a=1&b=2": []byte("0200ff"),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, ok := bodies[req.URL.RequestURI()]
+		if ok == false {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write(body)
+	}))
+	r := NewResolver(srv.URL)
+	err = r.Record(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for query := range bodies {
+		_, err := r.GetRawRequest(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = r.GetRawRequest("/rest/missing.json")
+	if errors.Is(err, ErrNotFound) == false {
+		t.Fatalf("missing resource: %v", err)
+	}
+	srv.Close()
+
+	// the replay serves the recorded responses without the endpoint
+	r = NewResolver(srv.URL)
+	r.Replay(dir)
+	for query, body := range bodies {
+		res, err := r.GetRawRequest(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// json bodies are stored indented
+		if json.Valid(res) == true {
+			buf := &bytes.Buffer{}
+			json.Compact(buf, res)
+			res = buf.Bytes()
+		}
+		if bytes.Equal(res, body) == false {
+			t.Errorf("replay %s %q, want %q", query, res, body)
+		}
+	}
+	info := struct {
+		Blocks int64 `json:"blocks"`
+	}{}
+	err = r.GetRequest("/rest/chaininfo.json", &info)
+	if err != nil || info.Blocks != 1 {
+		t.Errorf("replay chaininfo %+v %v", info, err)
+	}
+	// a recorded 404 and a query which was never recorded are not found
+	_, err = r.GetRawRequest("/rest/missing.json")
+	status := &StatusError{}
+	if errors.As(err, &status) == false || status.Code != 404 {
+		t.Errorf("replay of a recorded 404: %v", err)
+	}
+	_, err = r.GetRawRequest("/rest/unknown.json")
+	if errors.Is(err, ErrNotFound) == false {
+		t.Errorf("replay without a fixture: %v", err)
+	}
+}
@@ -5,8 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"net/http"
-	"strconv"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -16,18 +17,33 @@ type Resolver struct {
 	URI            string
 	Client         *http.Client
 	ContextTimeout time.Duration
+	endpoints      []*Endpoint
+	record         string
+	replay         string
+	// the best tip of the last health check
+	best int64
+	mu   sync.RWMutex
 }
 
 func NewResolver(uri string) *Resolver {
+	return NewMultiResolver([]string{uri})
+}
+
+// NewMultiResolver creates a resolver which fails over between the given
+// endpoints. URI is set to the first endpoint.
+func NewMultiResolver(uris []string) *Resolver {
 	client := &http.Client{Transport: &http.Transport{
 		MaxIdleConnsPerHost: 100,
 	}}
 	client.Timeout = 2 * time.Second
 	resolver := &Resolver{
-		URI:            uri,
+		URI:            uris[0],
 		Client:         client,
 		ContextTimeout: 2 * time.Second,
 	}
+	for _, uri := range uris {
+		resolver.endpoints = append(resolver.endpoints, &Endpoint{URI: uri, Healthy: true})
+	}
 	return resolver
 }
 
@@ -36,14 +52,90 @@ func (r *Resolver) SetTimeout(time time.Duration) {
 	r.ContextTimeout = time
 }
 
+// GetRequest tries the endpoints in order of preference until one succeeds.
+// When every endpoint fails the error of the first one is returned.
 func (r *Resolver) GetRequest(query string, res interface{}) error {
+	return r.failover(func(e *Endpoint) error {
+		return r.GetRequestFrom(e, query, res)
+	})
+}
+
+// GetRawRequest is GetRequest for non json responses such as .bin and .hex
+func (r *Resolver) GetRawRequest(query string) ([]byte, error) {
+	var body []byte
+	err := r.failover(func(e *Endpoint) error {
+		res, err := r.GetRawRequestFrom(e, query)
+		body = res
+		return err
+	})
+	return body, err
+}
+
+func (r *Resolver) failover(f func(e *Endpoint) error) error {
+	var first error
+	for _, e := range r.preferred() {
+		start := time.Now()
+		err := f(e)
+		if err == nil {
+			r.markSuccess(e, time.Since(start))
+			return nil
+		}
+		if first == nil {
+			first = err
+		}
+		// a lagging endpoint may not know the resource yet
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		r.markFailure(e, err)
+	}
+	return first
+}
+
+func (r *Resolver) GetRequestFrom(e *Endpoint, query string, res interface{}) error {
+	body, err := r.GetRawRequestFrom(e, query)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(body, res)
+	if err != nil {
+		return &DecodeError{query, err}
+	}
+	return nil
+}
+
+func (r *Resolver) GetRawRequestFrom(e *Endpoint, query string) ([]byte, error) {
+	r.mu.RLock()
+	record := r.record
+	replay := r.replay
+	r.mu.RUnlock()
+	if replay != "" {
+		return loadFixture(replay, query)
+	}
+	body, status, err := r.fetch(e, query)
+	if err != nil {
+		return nil, err
+	}
+	if record != "" {
+		err := saveFixture(record, query, status, body)
+		if err != nil {
+			log.Info(err)
+		}
+	}
+	if status != 200 {
+		return nil, &StatusError{status, query}
+	}
+	return body, nil
+}
+
+func (r *Resolver) fetch(e *Endpoint, query string) ([]byte, int, error) {
 	req, err := http.NewRequest(
 		"GET",
-		r.URI+query,
+		e.URI+query,
 		nil,
 	)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	ctx, cancel := context.WithTimeout(context.Background(), r.ContextTimeout)
@@ -52,17 +144,14 @@ func (r *Resolver) GetRequest(query string, res interface{}) error {
 	reqWithDeadline := req.WithContext(ctx)
 	resp, err := r.Client.Do(reqWithDeadline)
 	if err != nil {
-		return err
+		return nil, 0, wrapRequestError(query, err)
 	}
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		code := strconv.Itoa(resp.StatusCode)
-		return errors.New(" -> " + code + " " + query)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, wrapRequestError(query, err)
 	}
-	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(res)
-	resp.Body.Close()
-	return nil
+	return body, resp.StatusCode, nil
 }
 
 func (r *Resolver) PostRequest(uri string, jsonBody string, res interface{}) error {
@@ -82,10 +171,16 @@ func (r *Resolver) PostRequest(uri string, jsonBody string, res interface{}) err
 	resp, err := r.Client.Do(reqWithDeadline)
 	if err != nil {
 		log.Println("post err:", err)
-		return err
+		return wrapRequestError(uri, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return &StatusError{resp.StatusCode, uri}
 	}
 	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(res)
-	resp.Body.Close()
+	err = decoder.Decode(res)
+	if err != nil {
+		return &DecodeError{uri, wrapRequestError(uri, err)}
+	}
 	return nil
 }
@@ -6,9 +6,9 @@ import (
 	"sort"
 	"time"
 
-	"github.com/SwingbyProtocol/sc-indexer/btc"
+	"github.com/SwingbyProtocol/tx-indexer/btc"
 
-	"github.com/SwingbyProtocol/sc-indexer/resolver"
+	"github.com/SwingbyProtocol/tx-indexer/resolver"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -41,7 +41,7 @@ func Run() {
 			continue
 		}
 		list[tx.Txid] = true
-		log.Info(" tx -> ", tx.Txid, " minged -> ", tx.Confirms)
+		log.Info(" tx -> ", tx.Txid, " minged -> ", tx.BlockHeight)
 		for _, vout := range tx.Vout {
 			if vout.Spent {
 				if list[tx.Txid] == true {
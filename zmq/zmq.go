@@ -0,0 +1,164 @@
+package zmq
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const handshakeTimeout = 5 * time.Second
+
+// Subscriber is a SUB socket connected to a single publisher
+type Subscriber struct {
+	conn *conn
+}
+
+func Subscribe(addr string, topics []string) (*Subscriber, error) {
+	c, err := dial(addr, handshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	peer, err := c.handshake("SUB", false, handshakeTimeout)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if peer != "PUB" && peer != "XPUB" {
+		c.Close()
+		return nil, errors.New("zmq peer is not a publisher: " + peer)
+	}
+	// ZMTP 3.0 subscriptions are messages starting with 0x01
+	for _, topic := range topics {
+		err := c.writeFrame(append([]byte{1}, topic...), 0)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return &Subscriber{conn: c}, nil
+}
+
+// Recv blocks until the next message arrives. bitcoind messages have three
+// parts: topic, body and a little endian sequence number.
+func (s *Subscriber) Recv() ([][]byte, error) {
+	return s.conn.readMessage()
+}
+
+func (s *Subscriber) Close() error {
+	return s.conn.Close()
+}
+
+// Publisher is a PUB socket which can stand in for bitcoind locally
+type Publisher struct {
+	listener net.Listener
+	subs     map[*conn][]string
+	mu       sync.Mutex
+}
+
+func Listen(addr string) (*Publisher, error) {
+	listener, err := net.Listen("tcp", trimScheme(addr))
+	if err != nil {
+		return nil, err
+	}
+	p := &Publisher{
+		listener: listener,
+		subs:     make(map[*conn][]string),
+	}
+	go p.accept()
+	return p, nil
+}
+
+func (p *Publisher) Addr() string {
+	return "tcp://" + p.listener.Addr().String()
+}
+
+func (p *Publisher) accept() {
+	for {
+		nc, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.serve(newConn(nc))
+	}
+}
+
+func (p *Publisher) serve(c *conn) {
+	_, err := c.handshake("PUB", true, handshakeTimeout)
+	if err != nil {
+		log.Info("zmq handshake: ", err)
+		c.Close()
+		return
+	}
+	p.mu.Lock()
+	p.subs[c] = []string{}
+	p.mu.Unlock()
+	for {
+		body, flags, err := c.readFrame()
+		if err != nil {
+			break
+		}
+		if flags&flagCommand != 0 || len(body) == 0 {
+			continue
+		}
+		p.mu.Lock()
+		switch body[0] {
+		case 1:
+			p.subs[c] = append(p.subs[c], string(body[1:]))
+		case 0:
+			topics := []string{}
+			for _, topic := range p.subs[c] {
+				if topic != string(body[1:]) {
+					topics = append(topics, topic)
+				}
+			}
+			p.subs[c] = topics
+		}
+		p.mu.Unlock()
+	}
+	p.mu.Lock()
+	delete(p.subs, c)
+	p.mu.Unlock()
+	c.Close()
+}
+
+// Subscribers returns the number of connected subscribers
+func (p *Publisher) Subscribers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.subs)
+}
+
+// Publish sends a multipart message to every subscriber whose topic is a
+// prefix of the first part
+func (p *Publisher) Publish(parts ...[]byte) {
+	if len(parts) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for c, topics := range p.subs {
+		for _, topic := range topics {
+			if strings.HasPrefix(string(parts[0]), topic) == false {
+				continue
+			}
+			err := c.writeMessage(parts)
+			if err != nil {
+				c.Close()
+			}
+			break
+		}
+	}
+}
+
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	for c := range p.subs {
+		c.Close()
+	}
+	p.mu.Unlock()
+	return p.listener.Close()
+}
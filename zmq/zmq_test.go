@@ -0,0 +1,47 @@
+package zmq
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	pub, err := Listen("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	sub, err := Subscribe(pub.Addr(), []string{"raw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	done := make(chan [][]byte, 1)
+	go func() {
+		msg, err := sub.Recv()
+		if err != nil {
+			t.Error(err)
+		}
+		done <- msg
+	}()
+	// publish until the subscription has been read by the publisher
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		pub.Publish([]byte("hashblock"), []byte{1})
+		pub.Publish([]byte("rawtx"), bytes.Repeat([]byte{2}, 300), []byte{0, 0, 0, 0})
+		select {
+		case msg := <-done:
+			if len(msg) != 3 || string(msg[0]) != "rawtx" || len(msg[1]) != 300 {
+				t.Fatalf("message %q", msg)
+			}
+			if pub.Subscribers() != 1 {
+				t.Errorf("%d subscribers", pub.Subscribers())
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	t.Fatal("no message is received")
+}
@@ -0,0 +1,209 @@
+package zmq
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// A minimal ZMTP 3.0 implementation with the NULL security mechanism. It is
+// enough to talk to the PUB sockets of bitcoind (-zmqpub*).
+
+const (
+	flagMore    = 0x01
+	flagLong    = 0x02
+	flagCommand = 0x04
+	maxFrame    = 64 * 1024 * 1024
+)
+
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+}
+
+func dial(addr string, timeout time.Duration) (*conn, error) {
+	c, err := net.DialTimeout("tcp", trimScheme(addr), timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(c), nil
+}
+
+func newConn(c net.Conn) *conn {
+	return &conn{c: c, r: bufio.NewReader(c)}
+}
+
+func trimScheme(addr string) string {
+	return strings.TrimPrefix(addr, "tcp://")
+}
+
+func greeting(server bool) []byte {
+	g := make([]byte, 64)
+	g[0] = 0xff
+	g[9] = 0x7f
+	g[10] = 3
+	g[11] = 0
+	copy(g[12:32], "NULL")
+	if server == true {
+		g[32] = 1
+	}
+	return g
+}
+
+// handshake exchanges greetings and READY commands and returns the socket
+// type of the peer
+func (c *conn) handshake(socketType string, server bool, timeout time.Duration) (string, error) {
+	c.c.SetDeadline(time.Now().Add(timeout))
+	defer c.c.SetDeadline(time.Time{})
+	_, err := c.c.Write(greeting(server))
+	if err != nil {
+		return "", err
+	}
+	peer := make([]byte, 64)
+	_, err = io.ReadFull(c.r, peer)
+	if err != nil {
+		return "", err
+	}
+	if peer[0] != 0xff || peer[9] != 0x7f {
+		return "", errors.New("zmtp signature is invalid")
+	}
+	if peer[10] < 3 {
+		return "", errors.New("zmtp version is not supported")
+	}
+	if strings.TrimRight(string(peer[12:32]), "\x00") != "NULL" {
+		return "", errors.New("zmtp mechanism is not supported")
+	}
+	ready := []byte{5}
+	ready = append(ready, "READY"...)
+	ready = appendProperty(ready, "Socket-Type", socketType)
+	err = c.writeFrame(ready, flagCommand)
+	if err != nil {
+		return "", err
+	}
+	body, flags, err := c.readFrame()
+	if err != nil {
+		return "", err
+	}
+	if flags&flagCommand == 0 || len(body) < 6 || string(body[1:6]) != "READY" {
+		return "", errors.New("zmtp ready command is expected")
+	}
+	props, err := parseProperties(body[6:])
+	if err != nil {
+		return "", err
+	}
+	return props["Socket-Type"], nil
+}
+
+func appendProperty(b []byte, name string, value string) []byte {
+	b = append(b, byte(len(name)))
+	b = append(b, name...)
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(value)))
+	b = append(b, size...)
+	return append(b, value...)
+}
+
+func parseProperties(b []byte) (map[string]string, error) {
+	props := make(map[string]string)
+	for len(b) > 0 {
+		size := int(b[0])
+		if len(b) < 1+size+4 {
+			return nil, errors.New("zmtp property is invalid")
+		}
+		name := string(b[1 : 1+size])
+		b = b[1+size:]
+		vsize := int(binary.BigEndian.Uint32(b[:4]))
+		if len(b) < 4+vsize {
+			return nil, errors.New("zmtp property is invalid")
+		}
+		props[name] = string(b[4 : 4+vsize])
+		b = b[4+vsize:]
+	}
+	return props, nil
+}
+
+func (c *conn) writeFrame(body []byte, flags byte) error {
+	header := []byte{}
+	if len(body) > 255 {
+		header = append(header, flags|flagLong)
+		size := make([]byte, 8)
+		binary.BigEndian.PutUint64(size, uint64(len(body)))
+		header = append(header, size...)
+	} else {
+		header = append(header, flags, byte(len(body)))
+	}
+	_, err := c.c.Write(append(header, body...))
+	return err
+}
+
+func (c *conn) readFrame() ([]byte, byte, error) {
+	flags, err := c.r.ReadByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := uint64(0)
+	if flags&flagLong != 0 {
+		b := make([]byte, 8)
+		_, err := io.ReadFull(c.r, b)
+		if err != nil {
+			return nil, 0, err
+		}
+		size = binary.BigEndian.Uint64(b)
+	} else {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, 0, err
+		}
+		size = uint64(b)
+	}
+	if size > maxFrame {
+		return nil, 0, errors.New("zmtp frame is too large")
+	}
+	body := make([]byte, size)
+	_, err = io.ReadFull(c.r, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, flags, nil
+}
+
+// writeMessage sends a multipart message
+func (c *conn) writeMessage(parts [][]byte) error {
+	for i, part := range parts {
+		flags := byte(0)
+		if i < len(parts)-1 {
+			flags = flagMore
+		}
+		err := c.writeFrame(part, flags)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMessage reads a multipart message and skips commands such as PING
+func (c *conn) readMessage() ([][]byte, error) {
+	parts := [][]byte{}
+	for {
+		body, flags, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if flags&flagCommand != 0 {
+			continue
+		}
+		parts = append(parts, body)
+		if flags&flagMore == 0 {
+			return parts, nil
+		}
+	}
+}
+
+func (c *conn) Close() error {
+	return c.c.Close()
+}